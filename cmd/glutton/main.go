@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/defectus/glutton/pkg/common"
 )
@@ -10,6 +11,18 @@ import (
 const Glutton = "Glutton"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		if err := common.Decrypt(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("error decrypting: %+v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := common.Export(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("error exporting: %+v", err)
+		}
+		return
+	}
 	printVersionInfo()
 	err := common.Run()
 	if err != nil {
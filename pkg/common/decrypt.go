@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/seal"
+)
+
+// Decrypt is the `glutton decrypt` command. It reads the files given (or in) line by line, opens sealed payload and writes everything to out. Lines that are not sealed are copied as they are.
+func Decrypt(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	key := flags.String("key", os.Getenv("ENCRYPTION_MASTER_KEY"), "master key (base64), ENCRYPTION_MASTER_KEY by default")
+	identity := flags.String("identity", "", "age identity file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var identities io.Reader
+	if len(*identity) > 0 {
+		f, err := os.Open(*identity)
+		if err != nil {
+			return errors.Wrapf(err, "error opening identity file %s", *identity)
+		}
+		defer f.Close()
+		identities = f
+	}
+	opener, err := seal.NewOpener(*key, identities)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return decryptLines(opener, in, out)
+	}
+	for _, name := range flags.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return errors.Wrapf(err, "error opening %s", name)
+		}
+		err = decryptLines(opener, f, out)
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "error decrypting %s", name)
+		}
+	}
+	return nil
+}
+
+func decryptLines(opener *seal.Opener, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if !seal.IsSealed(text) {
+			if _, err := io.WriteString(out, text+"\n"); err != nil {
+				return err
+			}
+			continue
+		}
+		plain, err := opener.Open(text)
+		if err != nil {
+			return errors.Wrapf(err, "line %d", line)
+		}
+		if _, err = out.Write(plain); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/saver"
+)
+
+func TestDecrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key := make([]byte, 32)
+	rand.Read(key)
+	masterKey := base64.StdEncoding.EncodeToString(key)
+	s := new(saver.SimpleFileSystemSaver)
+	assert.NoError(t, s.Configure(&iface.Settings{OutputFolder: dir, BaseName: "sealed", EncryptionMasterKey: masterKey}))
+	payload := &iface.PayloadRecord{Payload: "email=john@example.com", Timestamp: time.Now(), Remote: "127.0.0.1"}
+	assert.NoError(t, s.Save(payload))
+	files, err := filepath.Glob(filepath.Join(dir, "sealed*"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	content, err := ioutil.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "john")
+	out := new(bytes.Buffer)
+	assert.NoError(t, Decrypt([]string{"-key", masterKey, files[0]}, nil, out))
+	assert.Equal(t, payload.String(), out.String())
+	// stdin and plain lines
+	out.Reset()
+	assert.NoError(t, Decrypt([]string{"-key", masterKey}, bytes.NewReader(append([]byte("plain\n"), content...)), out))
+	assert.Equal(t, "plain\n"+payload.String(), out.String())
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/saver"
+)
+
+// Export is the `glutton export` command. It reads records kept by the SQLiteSaver (no other saver) and writes them to out (or the file given) as WARC or HAR.
+func Export(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: glutton export [flags]\n\nExports records kept by the SQLiteSaver, databases of other savers (e.g. the DatabaseSaver) can't be read.")
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "warc", "output format, warc or har")
+	path := flags.String("db", "glutton.db", "sqlite database written by the SQLiteSaver")
+	route := flags.String("route", "", "export records of this route only")
+	output := flags.String("o", "", "output file, standard output if empty (a .gz suffix compresses warc)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*path); err != nil {
+		return errors.Wrapf(err, "error opening database %s", *path)
+	}
+	if len(*output) > 0 {
+		f, err := os.Create(*output)
+		if err != nil {
+			return errors.Wrapf(err, "error creating %s", *output)
+		}
+		defer f.Close()
+		out = f
+	}
+	buffered := bufio.NewWriter(out)
+	switch *format {
+	case "warc":
+		writer := saver.NewWARCWriter(buffered, strings.HasSuffix(*output, ".gz"))
+		if err := writer.WriteInfo(*output); err != nil {
+			return err
+		}
+		if err := saver.ReadSQLite(*path, *route, writer.WriteRequest); err != nil {
+			return err
+		}
+	case "har":
+		writer, err := saver.NewHARWriter(buffered)
+		if err != nil {
+			return err
+		}
+		if err = saver.ReadSQLite(*path, *route, writer.WriteEntry); err != nil {
+			return err
+		}
+		if err = writer.Close(); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unknown export format %s", *format)
+	}
+	return errors.Wrap(buffered.Flush(), "error writing export")
+}
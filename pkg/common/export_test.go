@@ -0,0 +1,57 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/saver"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	for _, route := range []string{"save", "other"} {
+		s := new(saver.SQLiteSaver)
+		assert.NoError(t, s.Configure(&iface.Settings{URI: route, SQLitePath: path}))
+		assert.NoError(t, s.Save(&iface.PayloadRecord{Payload: "a=1", Timestamp: time.Now(), Method: "POST", URL: "http://localhost:8080/v1/glutton/" + route, Received: 1500 * time.Microsecond}))
+		assert.NoError(t, s.Close())
+	}
+	out := new(bytes.Buffer)
+	assert.NoError(t, Export([]string{"-format", "har", "-db", path, "-route", "save"}, out))
+	var har struct {
+		Log struct {
+			Entries []struct {
+				Request struct {
+					URL string
+				}
+				Time float64
+			}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &har))
+	assert.Len(t, har.Log.Entries, 1)
+	assert.Equal(t, "http://localhost:8080/v1/glutton/save", har.Log.Entries[0].Request.URL)
+	assert.Equal(t, 1.5, har.Log.Entries[0].Time)
+	// records without URL target their route
+	s := new(saver.SQLiteSaver)
+	assert.NoError(t, s.Configure(&iface.Settings{URI: "legacy", SQLitePath: path}))
+	assert.NoError(t, s.Save(&iface.PayloadRecord{Payload: "a=1", Timestamp: time.Now()}))
+	assert.NoError(t, s.Close())
+	out.Reset()
+	assert.NoError(t, Export([]string{"-db", path}, out))
+	assert.Equal(t, 4, strings.Count(out.String(), "WARC/1.1\r\n"))
+	assert.Contains(t, out.String(), "WARC-Target-URI: http://localhost/v1/glutton/legacy\r\n")
+	assert.Error(t, Export([]string{"-format", "csv", "-db", path}, out))
+	assert.Error(t, Export([]string{"-db", filepath.Join(dir, "missing.db")}, out))
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/defectus/glutton/pkg/iface"
 	"github.com/gin-contrib/cors"
@@ -37,11 +38,33 @@ func Run() error {
 	}
 	appContext, cancelFunc := context.WithCancel(context.Background())
 	hookOnExit(cancelFunc)
-	go env.Server.Run(env.Configuration.Host + ":" + env.Configuration.Port)
+	server := &http.Server{Addr: env.Configuration.Host + ":" + env.Configuration.Port, Handler: env.Server}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("error running server %+v", err)
+			cancelFunc()
+		}
+	}()
 	<-appContext.Done()
+	shutdown(server, env)
 	return nil
 }
 
+// shutdown stops accepting requests, waits (for a while) for those in progress and closes all components. Components are closed in reverse order of creation, so wrappers flush before what they wrap is closed.
+func shutdown(server *http.Server, env *iface.Env) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down server %+v", err)
+	}
+	for i := len(env.Closers) - 1; i >= 0; i-- {
+		closer := env.Closers[i]
+		if err := closer.Close(); err != nil {
+			log.Printf("error closing %T %+v", closer, err)
+		}
+	}
+}
+
 // hookOnExit listens for signal SIGHUP and once received it closes the provided `closing` channel.
 func hookOnExit(cancelFunc context.CancelFunc) {
 	go func(cancelFunc context.CancelFunc) {
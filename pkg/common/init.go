@@ -1,14 +1,19 @@
 package common
 
 import (
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/defectus/glutton/pkg/filter"
 	"github.com/defectus/glutton/pkg/handler"
 	"github.com/defectus/glutton/pkg/iface"
 	"github.com/defectus/glutton/pkg/notifier"
@@ -54,6 +59,12 @@ func CreateEnvironment(configuration *iface.Configuration, env *iface.Env) *ifac
 			Parsers:   map[string]reflect.Type{},
 		}
 	}
+	if env.Filters == nil {
+		env.Filters = map[string]reflect.Type{}
+	}
+	if env.HealthCheckers == nil {
+		env.HealthCheckers = map[string]iface.HealthChecker{}
+	}
 	env.Configuration = configuration
 	if !configuration.Debug {
 		gin.SetMode(gin.ReleaseMode)
@@ -67,52 +78,209 @@ func CreateEnvironment(configuration *iface.Configuration, env *iface.Env) *ifac
 			notifier iface.PayloadNotifier
 			saver    iface.PayloadSaver
 			parser   iface.PayloadParser
-			err      error
 			ok       bool
 		)
 		if len(settings.Notifier) > 0 {
-			instance, err = createInstanceOf(env.Notifiers, settings.Notifier, &settings)
-			if err != nil {
-				log.Panicf("error creating notifier %+v", err)
+			names := splitList(settings.Notifier)
+			notifiers := []iface.PayloadNotifier{}
+			limit := rateLimit(&settings)
+			for _, name := range names {
+				instance = createComponent(env, env.Notifiers, name, &settings)
+				if notifier, ok = instance.(iface.PayloadNotifier); !ok {
+					log.Panicf("exptected notifier, got %s", reflect.TypeOf(instance))
+				}
+				notifiers = append(notifiers, digestNotifier(env, limitNotifier(limit, notifier), &settings))
 			}
-			if notifier, ok = instance.(iface.PayloadNotifier); !ok {
-				log.Panicf("exptected notifier, got %s", reflect.TypeOf(instance))
+			notifier = chainNotifiers(names, notifiers, &settings)
+			if settings.NotifyAsync {
+				notifier = asyncNotifier(env, notifier, &settings)
 			}
 		}
 		if len(settings.Saver) > 0 {
-			instance, err = createInstanceOf(env.Savers, settings.Saver, &settings)
-			if err != nil {
-				log.Panicf("error creating saver %+v", err)
-			}
-			if saver, ok = instance.(iface.PayloadSaver); !ok {
-				log.Panicf("exptected saver, got %s", reflect.TypeOf(instance))
+			savers := []iface.PayloadSaver{}
+			for _, name := range splitList(settings.Saver) {
+				instance = createComponent(env, env.Savers, name, &settings)
+				if saver, ok = instance.(iface.PayloadSaver); !ok {
+					log.Panicf("exptected saver, got %s", reflect.TypeOf(instance))
+				}
+				savers = append(savers, saver)
 			}
+			saver = chainSavers(savers)
 		}
 		if len(settings.Parser) > 0 {
-			instance, err = createInstanceOf(env.Parsers, settings.Parser, &settings)
-			if err != nil {
-				log.Panicf("error creating parser %+v", err)
-			}
+			instance = createComponent(env, env.Parsers, settings.Parser, &settings)
 			if parser, ok = instance.(iface.PayloadParser); !ok {
 				log.Panicf("exptected parser, got %s", reflect.TypeOf(instance))
 			}
 		}
-		h := handler.CreateHandler(settings.URI, parser, notifier, saver, settings.Debug)
+		filters := []iface.PayloadFilter{}
+		for _, name := range splitList(settings.Filter) {
+			instance = createComponent(env, env.Filters, name, &settings)
+			filter, ok := instance.(iface.PayloadFilter)
+			if !ok {
+				log.Panicf("exptected filter, got %s", reflect.TypeOf(instance))
+			}
+			filters = append(filters, filter)
+		}
+		sealed := len(settings.EncryptionMasterKey) > 0 || len(settings.EncryptionRecipient) > 0
+		h := handler.CreateHandler(settings.URI, parser, notifier, saver, settings.Debug, sealed, filters...)
+		// keys are kept only for requests with a valid token
+		if settings.UseIdempotencyKey {
+			h = idempotencyHandler(h, &settings)
+		}
 		if settings.UseToken {
 			h = handler.ValidateTokenHandler(h, settings.URI, []byte(settings.TokenKey), configuration.Debug)
 			gluttonRoute.GET(settings.URI+"/token", handler.CreateTokenHandler(settings.URI, []byte(settings.TokenKey), configuration.Debug))
 		}
-		gluttonRoute.POST(settings.URI, handler.RedirectHandler(h, http.StatusFound, settings.Redirect))
+		h = handler.RedirectHandler(h, http.StatusFound, settings.Redirect)
+		gluttonRoute.POST(settings.URI, h)
 	}
+	gluttonRoute.GET("health", handler.CreateHealthHandler(env.HealthCheckers))
 	return env
 }
 
 func registerCompoments(env *iface.Env) {
 	env.Notifiers["NilNotifier"] = reflect.TypeOf(notifier.NilNotifier{})
 	env.Notifiers["SMTPNotifier"] = reflect.TypeOf(notifier.SMTPNotifier{})
+	env.Notifiers["SlackNotifier"] = reflect.TypeOf(notifier.SlackNotifier{})
+	env.Notifiers["TeamsNotifier"] = reflect.TypeOf(notifier.TeamsNotifier{})
+	env.Notifiers["DiscordNotifier"] = reflect.TypeOf(notifier.DiscordNotifier{})
+	env.Notifiers["WebhookNotifier"] = reflect.TypeOf(notifier.WebhookNotifier{})
+	env.Notifiers["NtfyNotifier"] = reflect.TypeOf(notifier.NtfyNotifier{})
+	env.Notifiers["GotifyNotifier"] = reflect.TypeOf(notifier.GotifyNotifier{})
+	env.Notifiers["MatrixNotifier"] = reflect.TypeOf(notifier.MatrixNotifier{})
+	env.Notifiers["PagerDutyNotifier"] = reflect.TypeOf(notifier.PagerDutyNotifier{})
+	env.Notifiers["AlertmanagerNotifier"] = reflect.TypeOf(notifier.AlertmanagerNotifier{})
 	env.Savers["SimpleFileSystemSaver"] = reflect.TypeOf(saver.SimpleFileSystemSaver{})
 	env.Savers["DatabaseSaver"] = reflect.TypeOf(saver.DatabaseSaver{})
+	env.Savers["SQLiteSaver"] = reflect.TypeOf(saver.SQLiteSaver{})
+	env.Savers["WARCSaver"] = reflect.TypeOf(saver.WARCSaver{})
+	env.Savers["HARSaver"] = reflect.TypeOf(saver.HARSaver{})
+	env.Savers["MaildirSaver"] = reflect.TypeOf(saver.MaildirSaver{})
+	env.Savers["MboxSaver"] = reflect.TypeOf(saver.MboxSaver{})
+	env.Savers["S3Saver"] = reflect.TypeOf(saver.S3Saver{})
+	env.Savers["HTTPForwardSaver"] = reflect.TypeOf(saver.HTTPForwardSaver{})
+	env.Savers["KafkaSaver"] = reflect.TypeOf(saver.KafkaSaver{})
+	env.Savers["NATSSaver"] = reflect.TypeOf(saver.NATSSaver{})
+	env.Savers["AMQPSaver"] = reflect.TypeOf(saver.AMQPSaver{})
+	env.Savers["RedisSaver"] = reflect.TypeOf(saver.RedisSaver{})
+	env.Savers["ElasticsearchSaver"] = reflect.TypeOf(saver.ElasticsearchSaver{})
 	env.Parsers["SimpleParser"] = reflect.TypeOf(parser.SimpleParser{})
+	env.Filters["DedupFilter"] = reflect.TypeOf(filter.DedupFilter{})
+}
+
+// createComponent creates a component (parser, notifier, saver) of given name and keeps track of it if it can report its health or needs closing. Errors are fatal.
+func createComponent(env *iface.Env, types map[string]reflect.Type, name string, settings *iface.Settings) interface{} {
+	instance, err := createInstanceOf(types, name, settings)
+	if err != nil {
+		log.Panicf("error creating %s %+v", name, err)
+	}
+	if checker, ok := instance.(iface.HealthChecker); ok {
+		env.HealthCheckers[settings.URI+"/"+name] = checker
+	}
+	if closer, ok := instance.(io.Closer); ok {
+		env.Closers = append(env.Closers, closer)
+	}
+	return instance
+}
+
+// idempotencyHandler wraps the handler into idempotency key handling, bad settings are fatal.
+func idempotencyHandler(h gin.HandlerFunc, settings *iface.Settings) gin.HandlerFunc {
+	ttl, err := iface.ParseDuration(settings.IdempotencyTTL, 24*time.Hour)
+	if err != nil {
+		log.Panicf("error configuring idempotency of %s %+v", settings.URI, err)
+	}
+	header := settings.IdempotencyHeader
+	if len(header) == 0 {
+		header = "Idempotency-Key"
+	}
+	return handler.IdempotencyHandler(h, header, ttl, settings.Debug)
+}
+
+// rateLimit creates the rate limit of the route's notifiers, nil if not configured. Bad settings are fatal.
+func rateLimit(settings *iface.Settings) *notifier.RateLimitNotifier {
+	if settings.NotifyRateLimit <= 0 {
+		return nil
+	}
+	interval, err := iface.ParseDuration(settings.NotifyRateInterval, time.Hour)
+	if err != nil {
+		log.Panicf("error configuring notification rate limit of %s %+v", settings.URI, err)
+	}
+	return notifier.NewRateLimitNotifier(nil, settings.URI, settings.NotifyRateLimit, interval)
+}
+
+// limitNotifier wraps the notifier into the route's rate limit, if any. All notifiers of the route share the limit.
+func limitNotifier(limit *notifier.RateLimitNotifier, n iface.PayloadNotifier) iface.PayloadNotifier {
+	if limit == nil {
+		return n
+	}
+	return limit.Share(n)
+}
+
+// digestNotifier wraps the notifier into digests if configured, bad settings are fatal. Notifiers chosen by rules collect digests one by one, so digests hold only the payload their notifier was chosen for. The rate limit applies to the digests, payload over the limit waits for the next digest.
+func digestNotifier(env *iface.Env, n iface.PayloadNotifier, settings *iface.Settings) iface.PayloadNotifier {
+	if len(settings.DigestInterval) > 0 || settings.DigestSize > 0 {
+		interval, err := iface.ParseDuration(settings.DigestInterval, 0)
+		if err != nil {
+			log.Panicf("error configuring digests of %s %+v", settings.URI, err)
+		}
+		digest := notifier.NewDigestNotifier(n, settings.URI, interval, settings.DigestSize)
+		env.Closers = append(env.Closers, digest)
+		n = digest
+	}
+	return n
+}
+
+// chainNotifiers makes a single notifier of the given ones, notify rules choose which of them fire. Bad rules are fatal.
+func chainNotifiers(names []string, notifiers []iface.PayloadNotifier, settings *iface.Settings) iface.PayloadNotifier {
+	if len(notifiers) == 1 && len(settings.NotifyRules) == 0 {
+		return notifiers[0]
+	}
+	n, err := notifier.NewRuleNotifier(names, notifiers, settings.NotifyRules)
+	if err != nil {
+		log.Panicf("error configuring notify rules of %s %+v", settings.URI, err)
+	}
+	return n
+}
+
+// asyncNotifier runs the notifier in a worker pool reporting its queue in health checks, bad settings are fatal.
+func asyncNotifier(env *iface.Env, n iface.PayloadNotifier, settings *iface.Settings) iface.PayloadNotifier {
+	workers, queueSize, overflow, spillDir := settings.NotifyWorkers, settings.NotifyQueueSize, settings.NotifyOverflow, settings.NotifySpillDir
+	if workers == 0 {
+		workers = 2
+	}
+	if queueSize == 0 {
+		queueSize = 100
+	}
+	if len(overflow) == 0 {
+		overflow = notifier.OverflowDrop
+	}
+	if len(spillDir) == 0 {
+		spillDir = "glutton-spill"
+	}
+	if overflow == notifier.OverflowSpill {
+		// routes must not share spilled notifications
+		spillDir = filepath.Join(spillDir, strings.Trim(strings.ReplaceAll(settings.URI, "/", "_"), "_"))
+		// spilled notifications are read back, sealing them for a recipient only would lose them
+		if len(settings.EncryptionRecipient) > 0 && len(settings.EncryptionMasterKey) == 0 {
+			log.Panicf("error configuring asynchronous notifications of %s: spilling notifications of a route sealed for a recipient needs a master key", settings.URI)
+		}
+	}
+	async, err := notifier.NewAsyncNotifier(n, settings.URI, workers, queueSize, overflow, spillDir, settings.EncryptionMasterKey)
+	if err != nil {
+		log.Panicf("error configuring asynchronous notifications of %s %+v", settings.URI, err)
+	}
+	env.HealthCheckers[settings.URI+"/AsyncNotifier"] = async
+	env.Closers = append(env.Closers, async)
+	return async
+}
+
+// chainSavers makes a single saver of the given ones, more than one are run in the configured order.
+func chainSavers(savers []iface.PayloadSaver) iface.PayloadSaver {
+	if len(savers) == 1 {
+		return savers[0]
+	}
+	return &saver.MultiSaver{Savers: savers}
 }
 
 // createInstanceOf creates an instance of given name and configures it with the given settings (if implements the Configurable interface).
@@ -131,7 +299,7 @@ func createInstanceOf(types map[string]reflect.Type, name string, settings *ifac
 }
 
 // valueFromEnvVar recursively traverses supplied variable (pointer to a structure) and assign values based on each field's `env` tag. Should if containt an `env` and the corresponding env variable be empty the `default` tag's value is used.
-// Note that strings, bools and ints are supported at the moment.
+// Note that strings, bools, ints and slices of strings (comma separated) are supported at the moment.
 func valueFromEnvVar(value interface{}) error {
 	val := reflect.ValueOf(value)
 	if val.Kind() != reflect.Ptr {
@@ -159,6 +327,16 @@ func valueFromEnvVar(value interface{}) error {
 		case reflect.Bool:
 			bo, _ := strconv.ParseBool(v)
 			val.Field(i).SetBool(bo)
+		case reflect.Slice:
+			if val.Type().Field(i).Type.Elem().Kind() != reflect.String {
+				log.Printf("valueFromEnvVar: unsupported slice of %s at %s.", val.Type().Field(i).Type.Elem().Kind(), val.Type().Field(i).Name)
+				continue
+			}
+			separator := val.Type().Field(i).Tag.Get("sep")
+			if len(separator) == 0 {
+				separator = ","
+			}
+			val.Field(i).Set(reflect.ValueOf(splitBy(v, separator)))
 		case reflect.Ptr:
 			if val.Type().Field(i).Type.Elem().Kind() == reflect.Struct {
 				err := valueFromEnvVar(val.Field(i).Interface())
@@ -172,3 +350,19 @@ func valueFromEnvVar(value interface{}) error {
 	}
 	return nil
 }
+
+// splitList splits a comma separated list, items are trimmed and empty ones dropped.
+func splitList(value string) []string {
+	return splitBy(value, ",")
+}
+
+// splitBy splits a list of items separated by separator (e.g. newlines of rules whose patterns may hold commas), items are trimmed and empty ones dropped.
+func splitBy(value, separator string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, separator) {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/notifier"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/mock"
 
@@ -96,6 +97,38 @@ func TestValueFromEnvVar7(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestValueFromEnvVar8(t *testing.T) {
+	os.Setenv("TESTLIST", "a, b,,c")
+	value := &struct {
+		TestList    []string `env:"TESTLIST"`
+		TestDefault []string `env:"TESTDEFAULT" default:"x,y"`
+	}{}
+	err := valueFromEnvVar(value)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, value.TestList)
+	assert.Equal(t, []string{"x", "y"}, value.TestDefault)
+	os.Setenv("TESTRULES", "body=a{1,3} -> SMTPNotifier\n\n size>10 -> SlackNotifier")
+	rules := &struct {
+		TestRules []string `env:"TESTRULES" sep:"\n"`
+	}{}
+	assert.NoError(t, valueFromEnvVar(rules))
+	assert.Equal(t, []string{"body=a{1,3} -> SMTPNotifier", "size>10 -> SlackNotifier"}, rules.TestRules)
+}
+
+func TestValueFromEnvVar_Incident(t *testing.T) {
+	os.Setenv("PAGERDUTY_ROUTING_KEY", "R0UT1NG")
+	os.Setenv("ALERTMANAGER_URLS", "http://localhost:9093")
+	defer os.Unsetenv("PAGERDUTY_ROUTING_KEY")
+	defer os.Unsetenv("ALERTMANAGER_URLS")
+	settings := new(iface.Settings)
+	assert.NoError(t, valueFromEnvVar(settings))
+	// incidents without a dedup key aren't resolved, the resolve condition isn't required
+	assert.NoError(t, new(notifier.PagerDutyNotifier).Configure(settings))
+	assert.NoError(t, new(notifier.AlertmanagerNotifier).Configure(settings))
+	settings.IncidentDedupKey = []string{"json:id"}
+	assert.NoError(t, new(notifier.PagerDutyNotifier).Configure(settings))
+}
+
 type MockConfigurable struct {
 	mock.Mock
 }
@@ -216,3 +249,46 @@ settings:
 	assert.Equal(t, "/url", config.Settings[0].Redirect)
 	assert.Equal(t, "test", config.Settings[0].Parser)
 }
+
+func TestLimitNotifier(t *testing.T) {
+	n := &TestNotifier{}
+	assert.Nil(t, rateLimit(&iface.Settings{}))
+	assert.Equal(t, n, limitNotifier(nil, n))
+	assert.IsType(t, &notifier.RateLimitNotifier{}, limitNotifier(rateLimit(&iface.Settings{NotifyRateLimit: 10}), n))
+	assert.Panics(t, func() { rateLimit(&iface.Settings{NotifyRateLimit: 10, NotifyRateInterval: "often"}) })
+}
+
+func TestDigestNotifier(t *testing.T) {
+	env := &iface.Env{}
+	n := &TestNotifier{}
+	assert.Equal(t, n, digestNotifier(env, n, &iface.Settings{NotifyRateLimit: 10}))
+	digest := digestNotifier(env, n, &iface.Settings{DigestSize: 5})
+	assert.IsType(t, &notifier.DigestNotifier{}, digest)
+	assert.Len(t, env.Closers, 1)
+	assert.NoError(t, env.Closers[0].Close())
+	assert.Panics(t, func() { digestNotifier(env, n, &iface.Settings{DigestInterval: "often"}) })
+}
+
+func TestChainNotifiers(t *testing.T) {
+	n := &TestNotifier{}
+	assert.Equal(t, n, chainNotifiers([]string{"TestNotifier"}, []iface.PayloadNotifier{n}, &iface.Settings{}))
+	chained := chainNotifiers([]string{"TestNotifier"}, []iface.PayloadNotifier{n}, &iface.Settings{NotifyRules: []string{"size>0 -> TestNotifier"}})
+	assert.IsType(t, &notifier.RuleNotifier{}, chained)
+	assert.Panics(t, func() {
+		chainNotifiers([]string{"TestNotifier"}, []iface.PayloadNotifier{n}, &iface.Settings{NotifyRules: []string{"size>0 -> Other"}})
+	})
+}
+
+func TestAsyncNotifier(t *testing.T) {
+	env := &iface.Env{HealthCheckers: map[string]iface.HealthChecker{}}
+	async := asyncNotifier(env, &TestNotifier{}, &iface.Settings{URI: "/contact"})
+	assert.IsType(t, &notifier.AsyncNotifier{}, async)
+	assert.Equal(t, async, env.HealthCheckers["/contact/AsyncNotifier"])
+	assert.Len(t, env.Closers, 1)
+	assert.NoError(t, async.Notify(&iface.PayloadRecord{}))
+	assert.NoError(t, env.Closers[0].Close())
+	assert.Panics(t, func() { asyncNotifier(env, &TestNotifier{}, &iface.Settings{NotifyOverflow: "explode"}) })
+	assert.Panics(t, func() {
+		asyncNotifier(env, &TestNotifier{}, &iface.Settings{NotifyOverflow: "spill", NotifySpillDir: t.TempDir(), EncryptionRecipient: "age1recipient"})
+	})
+}
@@ -0,0 +1,215 @@
+// Package email builds RFC 5322 messages with MIME bodies, as used by the SMTPNotifier and the mailbox savers.
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is an email message. The text and HTML bodies are alternatives, either may be empty.
+type Message struct {
+	From      string
+	To        []string
+	Cc        []string
+	ReplyTo   string
+	Subject   string
+	Date      time.Time
+	MessageID string
+	// Header holds additional header fields.
+	Header      map[string]string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// NewMessageID creates a unique message id for the given domain (the host name if empty).
+func NewMessageID(domain string) string {
+	if len(domain) == 0 {
+		domain, _ = os.Hostname()
+	}
+	if len(domain) == 0 {
+		domain = "glutton"
+	}
+	return "<" + uuid.NewString() + "@" + domain + ">"
+}
+
+// Bytes renders the message with CRLF line endings. Date and Message-ID are filled in if missing.
+func (m *Message) Bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := m.MessageID
+	if len(messageID) == 0 {
+		messageID = NewMessageID(domainOf(m.From))
+	}
+	writeField(&buffer, "From", m.From)
+	writeField(&buffer, "To", strings.Join(m.To, ", "))
+	writeField(&buffer, "Cc", strings.Join(m.Cc, ", "))
+	writeField(&buffer, "Reply-To", m.ReplyTo)
+	writeField(&buffer, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeField(&buffer, "Date", date.Format(time.RFC1123Z))
+	writeField(&buffer, "Message-ID", messageID)
+	names := make([]string, 0, len(m.Header))
+	for name := range m.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeField(&buffer, name, mime.QEncoding.Encode("utf-8", m.Header[name]))
+	}
+	writeField(&buffer, "MIME-Version", "1.0")
+	header, body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Attachments) == 0 {
+		writeHeader(&buffer, header)
+		buffer.Write(body)
+		return buffer.Bytes(), nil
+	}
+	mixed := multipart.NewWriter(&buffer)
+	writeField(&buffer, "Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buffer.WriteString("\r\n")
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "error writing message")
+	}
+	part.Write(body)
+	for _, attachment := range m.Attachments {
+		contentType := attachment.ContentType
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Transfer-Encoding", "base64")
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+		part, err := mixed.CreatePart(header)
+		if err != nil {
+			return nil, errors.Wrap(err, "error writing attachment")
+		}
+		writeBase64(part, attachment.Data)
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, errors.Wrap(err, "error writing message")
+	}
+	return buffer.Bytes(), nil
+}
+
+// body renders the text and/or HTML body as a MIME entity, it returns the entity's header and content.
+func (m *Message) body() (textproto.MIMEHeader, []byte, error) {
+	var content bytes.Buffer
+	if len(m.HTML) > 0 && len(m.Text) > 0 {
+		alternative := multipart.NewWriter(&content)
+		if err := writeText(alternative, "text/plain", m.Text); err != nil {
+			return nil, nil, err
+		}
+		if err := writeText(alternative, "text/html", m.HTML); err != nil {
+			return nil, nil, err
+		}
+		if err := alternative.Close(); err != nil {
+			return nil, nil, errors.Wrap(err, "error writing message")
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "multipart/alternative; boundary="+alternative.Boundary())
+		return header, content.Bytes(), nil
+	}
+	contentType, text := "text/plain", m.Text
+	if len(m.HTML) > 0 {
+		contentType, text = "text/html", m.HTML
+	}
+	if err := writeQuotedPrintable(&content, text); err != nil {
+		return nil, nil, err
+	}
+	return textHeader(contentType), content.Bytes(), nil
+}
+
+// writeHeader writes header fields sorted by name and ends the header.
+func writeHeader(buffer *bytes.Buffer, header textproto.MIMEHeader) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeField(buffer, name, header.Get(name))
+	}
+	buffer.WriteString("\r\n")
+}
+
+func textHeader(contentType string) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return header
+}
+
+func writeText(w *multipart.Writer, contentType, text string) error {
+	part, err := w.CreatePart(textHeader(contentType))
+	if err != nil {
+		return errors.Wrap(err, "error writing message part")
+	}
+	var buffer bytes.Buffer
+	if err = writeQuotedPrintable(&buffer, text); err != nil {
+		return err
+	}
+	_, err = part.Write(buffer.Bytes())
+	return errors.Wrap(err, "error writing message part")
+}
+
+func writeQuotedPrintable(w *bytes.Buffer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	// quoted printable keeps line breaks as they are, make them CRLF
+	if _, err := qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n"))); err != nil {
+		return errors.Wrap(err, "error encoding message text")
+	}
+	return errors.Wrap(qp.Close(), "error encoding message text")
+}
+
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+func writeField(buffer *bytes.Buffer, name, value string) {
+	if len(value) == 0 {
+		return
+	}
+	// header injection is not possible through values
+	value = strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+	fmt.Fprintf(buffer, "%s: %s\r\n", name, value)
+}
+
+// domainOf returns the domain of an address, empty if there is none.
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.Trim(address[i+1:], "> ")
+	}
+	return ""
+}
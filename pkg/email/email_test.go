@@ -0,0 +1,74 @@
+package email
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_Bytes(t *testing.T) {
+	m := &Message{
+		From:    "glutton@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Příliš žluťoučký",
+		Date:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Header:  map[string]string{"X-Test": "injected\r\nBcc: evil@example.com"},
+		Text:    "hello\nworld",
+		HTML:    "<p>hello</p>",
+		Attachments: []Attachment{
+			{Filename: "payload.bin", Data: bytes.Repeat([]byte{0, 1, 2}, 100)},
+		},
+	}
+	raw, err := m.Bytes()
+	assert.NoError(t, err)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+	assert.Equal(t, "a@example.com, b@example.com", msg.Header.Get("To"))
+	assert.Empty(t, msg.Header.Get("Bcc"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Příliš žluťoučký", subject)
+	date, err := msg.Header.Date()
+	assert.NoError(t, err)
+	assert.True(t, m.Date.Equal(date))
+	assert.Regexp(t, `^<.+@example.com>$`, msg.Header.Get("Message-ID"))
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	mixed := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := mixed.NextPart()
+	assert.NoError(t, err)
+	mediaType, params, err = mime.ParseMediaType(body.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	alternative := multipart.NewReader(body, params["boundary"])
+	text, err := alternative.NextPart()
+	assert.NoError(t, err)
+	content, _ := ioutil.ReadAll(text)
+	assert.Equal(t, "hello\r\nworld", string(content))
+	html, err := alternative.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", html.Header.Get("Content-Type"))
+	attachment, err := mixed.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "payload.bin", attachment.FileName())
+	assert.Equal(t, "base64", attachment.Header.Get("Content-Transfer-Encoding"))
+}
+
+func TestMessage_BytesPlain(t *testing.T) {
+	raw, err := (&Message{From: "glutton@example.com", To: []string{"a@example.com"}, Text: "just text"}).Bytes()
+	assert.NoError(t, err)
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", msg.Header.Get("Content-Type"))
+	assert.NotEmpty(t, msg.Header.Get("Date"))
+	content, _ := ioutil.ReadAll(msg.Body)
+	assert.Equal(t, "just text", string(content))
+}
@@ -0,0 +1,139 @@
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dedupSkip drops duplicates altogether.
+	dedupSkip = "skip"
+	// dedupReference saves duplicates without their body, referencing the payload stored first.
+	dedupReference = "reference"
+)
+
+// seenPayload is a hash and the time it was seen first.
+type seenPayload struct {
+	hash string
+	at   time.Time
+}
+
+// DedupFilter drops (or reduces to a reference) payloads identical to one seen within a window. Payloads are identified by a hash of their body and selected headers, hashes are kept in memory.
+type DedupFilter struct {
+	window  time.Duration
+	headers []string
+	mode    string
+	mutex   sync.Mutex
+	seen    map[string]time.Time
+	// order keeps hashes in the order they were seen, so they can be expired oldest first
+	order []seenPayload
+	// pending holds hashes of new payloads until they are saved
+	pending map[*iface.PayloadRecord]string
+	// reserved holds hashes of pending payloads and when they were seen, so concurrent duplicates are told apart too
+	reserved map[string]time.Time
+	debug    bool
+}
+
+// Configure bootstraps the DedupFilter.
+// Namely the following params are used:
+// * DedupWindow - how long a payload is remembered
+// * DedupHeaders - headers that are part of the payload's identity along with the body
+// * DedupMode - `skip` (default) to drop duplicates, `reference` to save them without body
+func (d *DedupFilter) Configure(settings *iface.Settings) (err error) {
+	if d.window, err = iface.ParseDuration(settings.DedupWindow, 5*time.Minute); err != nil {
+		return err
+	}
+	d.mode = settings.DedupMode
+	if len(d.mode) == 0 {
+		d.mode = dedupSkip
+	}
+	if d.mode != dedupSkip && d.mode != dedupReference {
+		return errors.Errorf("unknown dedup mode %s", d.mode)
+	}
+	d.headers = make([]string, 0, len(settings.DedupHeaders))
+	for _, name := range settings.DedupHeaders {
+		d.headers = append(d.headers, http.CanonicalHeaderKey(name))
+	}
+	sort.Strings(d.headers)
+	d.seen = map[string]time.Time{}
+	d.pending = map[*iface.PayloadRecord]string{}
+	d.reserved = map[string]time.Time{}
+	d.debug = settings.Debug
+	return nil
+}
+
+// Filter tells whether the payload is new. New payload is reserved until committed, it's remembered if saved and forgotten otherwise. Duplicates, of saved payloads as well as of those being saved, are dropped or, in the reference mode, stripped of body and marked as such.
+func (d *DedupFilter) Filter(payload *iface.PayloadRecord) bool {
+	hash := d.Hash(payload)
+	now := time.Now()
+	d.mutex.Lock()
+	d.expire(now)
+	firstSeen, found := d.seen[hash]
+	if !found {
+		firstSeen, found = d.reserved[hash]
+	}
+	if !found {
+		d.pending[payload] = hash
+		d.reserved[hash] = now
+	}
+	d.mutex.Unlock()
+	if !found {
+		return true
+	}
+	if d.debug {
+		log.Printf("DedupFilter_Filter: duplicate of %s first seen %s", hash, firstSeen)
+	}
+	if d.mode == dedupSkip {
+		return false
+	}
+	payload.Duplicate = &iface.Duplicate{Hash: hash, FirstSeen: firstSeen, Body: payload.Payload}
+	payload.Payload = ""
+	return true
+}
+
+// Commit remembers the new payload if it was saved and releases its reservation otherwise, so a payload failed to be saved can be retried.
+func (d *DedupFilter) Commit(payload *iface.PayloadRecord, saved bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	hash, found := d.pending[payload]
+	if !found {
+		return
+	}
+	delete(d.pending, payload)
+	delete(d.reserved, hash)
+	if !saved {
+		return
+	}
+	now := time.Now()
+	d.seen[hash] = now
+	d.order = append(d.order, seenPayload{hash, now})
+}
+
+// Hash computes the identity of the payload, the sha256 of the selected headers and the body.
+func (d *DedupFilter) Hash(payload *iface.PayloadRecord) string {
+	h := sha256.New()
+	for _, name := range d.headers {
+		h.Write([]byte(name + ": " + strings.Join(http.Header(payload.Meta).Values(name), ", ") + "\n"))
+	}
+	h.Write([]byte("\n"))
+	h.Write([]byte(payload.Payload))
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// expire forgets payloads seen before the window, must be called with the mutex held.
+func (d *DedupFilter) expire(now time.Time) {
+	i := 0
+	for ; i < len(d.order) && now.Sub(d.order[i].at) >= d.window; i++ {
+		delete(d.seen, d.order[i].hash)
+	}
+	d.order = d.order[i:]
+}
@@ -0,0 +1,72 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupFilter_Filter(t *testing.T) {
+	d := new(DedupFilter)
+	assert.NoError(t, d.Configure(&iface.Settings{DedupHeaders: []string{"x-signature"}}))
+	first := &iface.PayloadRecord{Payload: "a=1", Meta: map[string][]string{"X-Signature": {"abc"}, "Date": {"now"}}}
+	assert.True(t, d.Filter(first))
+	// not saved, not remembered
+	d.Commit(first, false)
+	assert.True(t, d.Filter(first))
+	d.Commit(first, true)
+	// other headers don't matter
+	assert.False(t, d.Filter(&iface.PayloadRecord{Payload: "a=1", Meta: map[string][]string{"X-Signature": {"abc"}, "Date": {"later"}}}))
+	assert.True(t, d.Filter(&iface.PayloadRecord{Payload: "a=1", Meta: map[string][]string{"X-Signature": {"def"}}}))
+	assert.True(t, d.Filter(&iface.PayloadRecord{Payload: "a=2", Meta: map[string][]string{"X-Signature": {"abc"}}}))
+}
+
+func TestDedupFilter_reference(t *testing.T) {
+	d := new(DedupFilter)
+	assert.NoError(t, d.Configure(&iface.Settings{DedupMode: "reference"}))
+	first := &iface.PayloadRecord{Payload: "a=1"}
+	assert.True(t, d.Filter(first))
+	d.Commit(first, true)
+	duplicate := &iface.PayloadRecord{Payload: "a=1"}
+	assert.True(t, d.Filter(duplicate))
+	assert.Empty(t, duplicate.Payload)
+	assert.NotNil(t, duplicate.Duplicate)
+	assert.Equal(t, d.Hash(&iface.PayloadRecord{Payload: "a=1"}), duplicate.Duplicate.Hash)
+	assert.Equal(t, "a=1", duplicate.Duplicate.Body)
+	assert.Contains(t, duplicate.String(), "duplicate of sha256:")
+}
+
+func TestDedupFilter_pending(t *testing.T) {
+	d := new(DedupFilter)
+	assert.NoError(t, d.Configure(&iface.Settings{}))
+	first := &iface.PayloadRecord{Payload: "a=1"}
+	assert.True(t, d.Filter(first))
+	// a duplicate arriving while the first one is being saved
+	concurrent := &iface.PayloadRecord{Payload: "a=1"}
+	assert.False(t, d.Filter(concurrent))
+	d.Commit(concurrent, false)
+	d.Commit(first, false)
+	retried := &iface.PayloadRecord{Payload: "a=1"}
+	assert.True(t, d.Filter(retried))
+	d.Commit(retried, true)
+	assert.False(t, d.Filter(&iface.PayloadRecord{Payload: "a=1"}))
+	assert.Empty(t, d.reserved)
+}
+
+func TestDedupFilter_window(t *testing.T) {
+	d := new(DedupFilter)
+	assert.NoError(t, d.Configure(&iface.Settings{DedupWindow: "50ms"}))
+	first := &iface.PayloadRecord{Payload: "a=1"}
+	assert.True(t, d.Filter(first))
+	d.Commit(first, true)
+	assert.False(t, d.Filter(&iface.PayloadRecord{Payload: "a=1"}))
+	time.Sleep(60 * time.Millisecond)
+	again := &iface.PayloadRecord{Payload: "a=1"}
+	assert.True(t, d.Filter(again))
+	d.Commit(again, true)
+	assert.Len(t, d.order, 1)
+	assert.Empty(t, d.pending)
+	assert.Error(t, d.Configure(&iface.Settings{DedupMode: "unknown"}))
+}
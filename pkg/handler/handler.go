@@ -22,6 +22,24 @@ func CreateTokenHandler(uri string, key []byte, debug bool) gin.HandlerFunc {
 	}
 }
 
+// CreateHealthHandler setups a handler reporting health of the given components. If any of them is unhealthy the status is 503.
+func CreateHealthHandler(checkers map[string]iface.HealthChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, checks := http.StatusOK, map[string]string{}
+		for name, checker := range checkers {
+			checks[name] = "ok"
+			if reporter, ok := checker.(iface.HealthReporter); ok {
+				checks[name] = reporter.HealthStatus()
+			}
+			if err := checker.Health(); err != nil {
+				status = http.StatusServiceUnavailable
+				checks[name] = err.Error()
+			}
+		}
+		c.JSON(status, map[string]interface{}{"status": http.StatusText(status), "checks": checks})
+	}
+}
+
 // RedirectHandler wraps the supplied handler into a redirect call. If the location parameters is empty no redirect is performed.
 func RedirectHandler(h gin.HandlerFunc, code int, location string) gin.HandlerFunc {
 	if len(location) == 0 {
@@ -49,24 +67,63 @@ func ValidateTokenHandler(h gin.HandlerFunc, uri string, key []byte, debug bool)
 	}
 }
 
-// CreateHandler appends a route to router and initialize the basic flow (request -> parser -> notifier -> saver)
-func CreateHandler(URI string, parser iface.PayloadParser, notifier iface.PayloadNotifier, saver iface.PayloadSaver, debug bool) gin.HandlerFunc {
+// CreateHandler appends a route to router and initialize the basic flow (request -> parser -> filters -> notifier -> saver). Payload dropped by a filter is neither notified of nor saved, duplicates are not notified of. Filters are told whether the payload was saved (by the primary saver of several), a failed save is recorded in the context errors. Payload of a sealed route is never logged.
+func CreateHandler(URI string, parser iface.PayloadParser, notifier iface.PayloadNotifier, saver iface.PayloadSaver, debug, sealed bool, filters ...iface.PayloadFilter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		payload, err := parser.Parse(c.Request)
 		if err != nil {
 			log.Printf("%s: error parsing contents %+v", URI, err)
 			log.Printf("%+v", c.Request)
 		}
-		err = notifier.Notify(payload)
-		if err != nil {
-			log.Printf("%s: error notifying of payload %+v", URI, err)
-			log.Printf("%+v", payload)
+		for i, filter := range filters {
+			if payload != nil && !filter.Filter(payload) {
+				commit(filters[:i], payload, false)
+				if debug {
+					log.Printf("%s: payload dropped by %T", URI, filter)
+				}
+				c.Status(http.StatusOK)
+				return
+			}
+		}
+		if payload == nil || payload.Duplicate == nil {
+			err = notifier.Notify(payload)
+			if err != nil {
+				log.Printf("%s: error notifying of payload %+v", URI, err)
+				logPayload(URI, payload, sealed)
+			}
 		}
 		err = saver.Save(payload)
+		saved := err == nil
+		if partial, ok := err.(iface.PartialSaveError); ok {
+			saved = partial.Saved()
+		}
+		commit(filters, payload, saved)
 		if err != nil {
+			c.Error(err)
 			log.Printf("%s: error saving payload %+v", URI, err)
-			log.Printf("%+v", payload)
+			logPayload(URI, payload, sealed)
 		}
 		c.Status(http.StatusOK)
 	}
 }
+
+// commit tells the filters that let the payload through whether it was saved.
+func commit(filters []iface.PayloadFilter, payload *iface.PayloadRecord, saved bool) {
+	if payload == nil {
+		return
+	}
+	for _, filter := range filters {
+		if committer, ok := filter.(iface.PayloadCommitter); ok {
+			committer.Commit(payload, saved)
+		}
+	}
+}
+
+// logPayload logs the payload that failed, only its size if it's sealed.
+func logPayload(URI string, payload *iface.PayloadRecord, sealed bool) {
+	if sealed && payload != nil {
+		log.Printf("%s: payload of %d bytes not logged, the route is sealed", URI, len(payload.Payload))
+		return
+	}
+	log.Printf("%+v", payload)
+}
@@ -1,14 +1,18 @@
 package handler_test
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"testing"
 
 	"github.com/defectus/glutton/pkg/common"
+	"github.com/defectus/glutton/pkg/filter"
 	"github.com/defectus/glutton/pkg/handler"
 	"github.com/defectus/glutton/pkg/iface"
 	"github.com/gin-gonic/gin"
@@ -102,7 +106,7 @@ func TestCreateHandler(t *testing.T) {
 	mn := &MockNotifier{}
 	mn.On("Notify").Return(nil)
 	router := gin.Default()
-	router.POST("test", handler.CreateHandler("test", mp, mn, ms, false))
+	router.POST("test", handler.CreateHandler("test", mp, mn, ms, false, false))
 	req, _ := http.NewRequest("POST", "http://localhost/test", nil)
 	testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
 		assert.Equal(t, http.StatusOK, w.Code)
@@ -115,6 +119,74 @@ func TestCreateHandler(t *testing.T) {
 	})
 }
 
+func TestCreateHandlerFilter(t *testing.T) {
+	mp := &MockParser{}
+	mp.On("Parse").Return(&iface.PayloadRecord{Payload: "same"}, nil)
+	ms := &MockSaver{}
+	ms.On("Save").Return(nil)
+	mn := &MockNotifier{}
+	mn.On("Notify").Return(nil)
+	dedup := &filter.DedupFilter{}
+	assert.NoError(t, dedup.Configure(&iface.Settings{}))
+	router := gin.Default()
+	router.POST("test", handler.CreateHandler("test", mp, mn, ms, false, false, dedup))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+		testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
+			assert.Equal(t, http.StatusOK, w.Code)
+			return true
+		})
+	}
+	mp.AssertNumberOfCalls(t, "Parse", 2)
+	ms.AssertNumberOfCalls(t, "Save", 1)
+	mn.AssertNumberOfCalls(t, "Notify", 1)
+}
+
+func TestCreateHandlerFilterFailedSave(t *testing.T) {
+	mp := &MockParser{}
+	mp.On("Parse").Return(&iface.PayloadRecord{Payload: "same"}, nil)
+	ms := &MockSaver{}
+	ms.On("Save").Return(errors.New("disk full")).Once()
+	ms.On("Save").Return(nil)
+	mn := &MockNotifier{}
+	mn.On("Notify").Return(nil)
+	dedup := &filter.DedupFilter{}
+	assert.NoError(t, dedup.Configure(&iface.Settings{}))
+	router := gin.Default()
+	router.POST("test", handler.CreateHandler("test", mp, mn, ms, false, false, dedup))
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+		testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
+			return true
+		})
+	}
+	// the failed save is retried, the successful one is deduplicated
+	ms.AssertNumberOfCalls(t, "Save", 2)
+	mn.AssertNumberOfCalls(t, "Notify", 2)
+}
+
+func TestCreateHandlerSealed(t *testing.T) {
+	mp := &MockParser{}
+	mp.On("Parse").Return(&iface.PayloadRecord{Payload: "secret payload"}, nil)
+	ms := &MockSaver{}
+	ms.On("Save").Return(errors.New("disk full"))
+	mn := &MockNotifier{}
+	mn.On("Notify").Return(errors.New("smtp down"))
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	router := gin.Default()
+	router.POST("test", handler.CreateHandler("test", mp, mn, ms, false, true))
+	req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+	testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
+		assert.Equal(t, http.StatusOK, w.Code)
+		return true
+	})
+	assert.Contains(t, logged.String(), "disk full")
+	assert.Contains(t, logged.String(), "payload of 14 bytes not logged")
+	assert.NotContains(t, logged.String(), "secret payload")
+}
+
 func TestCreateRedirectHandlerNoRedirect(t *testing.T) {
 	mp := &MockParser{}
 	mp.On("Parse").Return(&iface.PayloadRecord{}, nil)
@@ -123,7 +195,7 @@ func TestCreateRedirectHandlerNoRedirect(t *testing.T) {
 	mn := &MockNotifier{}
 	mn.On("Notify").Return(nil)
 	router := gin.Default()
-	router.POST("test", handler.RedirectHandler(handler.CreateHandler("test", mp, mn, ms, false), http.StatusTemporaryRedirect, ""))
+	router.POST("test", handler.RedirectHandler(handler.CreateHandler("test", mp, mn, ms, false, false), http.StatusTemporaryRedirect, ""))
 	req, _ := http.NewRequest("POST", "http://localhost/test", nil)
 	testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
 		assert.Equal(t, http.StatusOK, w.Code)
@@ -144,7 +216,7 @@ func TestCreateRedirectHandlerRedirect(t *testing.T) {
 	mn := &MockNotifier{}
 	mn.On("Notify").Return(nil)
 	router := gin.Default()
-	router.POST("test", handler.RedirectHandler(handler.CreateHandler("test", mp, mn, ms, false), http.StatusTemporaryRedirect, "https://test.redirect"))
+	router.POST("test", handler.RedirectHandler(handler.CreateHandler("test", mp, mn, ms, false, false), http.StatusTemporaryRedirect, "https://test.redirect"))
 	req, _ := http.NewRequest("POST", "http://localhost/test", nil)
 	testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
 		assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
@@ -251,6 +323,48 @@ func TestValidateToken2(t *testing.T) {
 	})
 }
 
+type TestHealthChecker struct {
+	err error
+}
+
+func (t *TestHealthChecker) Health() error {
+	return t.err
+}
+
+type TestHealthReporter struct {
+	TestHealthChecker
+}
+
+func (t *TestHealthReporter) HealthStatus() string {
+	return "ok, queued 1/100"
+}
+
+func TestCreateHealthHandler(t *testing.T) {
+	router := gin.Default()
+	router.GET("healthy", handler.CreateHealthHandler(map[string]iface.HealthChecker{
+		"save/TestSaver":     &TestHealthChecker{},
+		"save/AsyncNotifier": &TestHealthReporter{},
+	}))
+	router.GET("unhealthy", handler.CreateHealthHandler(map[string]iface.HealthChecker{
+		"save/TestSaver": &TestHealthChecker{},
+		"save/DBSaver":   &TestHealthChecker{errors.New("connection refused")},
+	}))
+	req, _ := http.NewRequest("GET", "http://localhost/healthy", nil)
+	testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
+		assert.Equal(t, http.StatusOK, w.Code)
+		p, _ := ioutil.ReadAll(w.Body)
+		assert.Contains(t, string(p), "queued 1/100")
+		return true
+	})
+	req, _ = http.NewRequest("GET", "http://localhost/unhealthy", nil)
+	testHTTPResponse(t, router, req, func(w *httptest.ResponseRecorder) bool {
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		p, _ := ioutil.ReadAll(w.Body)
+		assert.Contains(t, string(p), "connection refused")
+		return true
+	})
+}
+
 func testHTTPResponse(t *testing.T, r *gin.Engine, req *http.Request, f func(w *httptest.ResponseRecorder) bool) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
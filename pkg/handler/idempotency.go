@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyReplayedHeader is set on responses replayed from the cache.
+const IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+// cachedResponse is a response of a request with an idempotency key. done is closed once the response is known, stored tells whether it is kept.
+type cachedResponse struct {
+	key     string
+	request [sha256.Size]byte
+	stored  bool
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+	done    chan struct{}
+}
+
+// capturingWriter keeps a copy of the response body.
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// idempotencyCache holds responses by key, expired ones are dropped oldest first.
+type idempotencyCache struct {
+	mutex     sync.Mutex
+	responses map[string]*cachedResponse
+	// order keeps responses in the order they were created, a forgotten response stays until it expires
+	order []*cachedResponse
+}
+
+// expire drops expired responses, must be called with the mutex held.
+func (i *idempotencyCache) expire(now time.Time) {
+	n := 0
+	for ; n < len(i.order) && !now.Before(i.order[n].expires); n++ {
+		if i.responses[i.order[n].key] == i.order[n] {
+			delete(i.responses, i.order[n].key)
+		}
+	}
+	i.order = i.order[n:]
+}
+
+// IdempotencyHandler wraps the supplied handler so that requests carrying the same key in the given header are processed only once within the ttl. Later requests get the response of the first one replayed, requests coming while the first one is still processed wait for it. A key reused with a different body is answered 422. Failed responses (4xx, 5xx or errors recorded in the context, e.g. a failed save) are not kept, so the client may retry.
+func IdempotencyHandler(h gin.HandlerFunc, header string, ttl time.Duration, debug bool) gin.HandlerFunc {
+	cache := &idempotencyCache{responses: map[string]*cachedResponse{}}
+	return func(c *gin.Context) {
+		key := c.GetHeader(header)
+		if len(key) == 0 {
+			h(c)
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			if body, err = ioutil.ReadAll(c.Request.Body); err != nil {
+				log.Printf("IdempotencyHandler: error reading request %+v", err)
+				c.Status(http.StatusBadRequest)
+				return
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		request := sha256.Sum256(body)
+		var (
+			response *cachedResponse
+			found    bool
+		)
+		for {
+			now := time.Now()
+			cache.mutex.Lock()
+			cache.expire(now)
+			response, found = cache.responses[key]
+			if !found {
+				response = &cachedResponse{key: key, request: request, expires: now.Add(ttl), done: make(chan struct{})}
+				cache.responses[key] = response
+				cache.order = append(cache.order, response)
+			}
+			cache.mutex.Unlock()
+			if !found {
+				break
+			}
+			if response.request != request {
+				if debug {
+					log.Printf("IdempotencyHandler: key %s reused with a different request", key)
+				}
+				c.Status(http.StatusUnprocessableEntity)
+				return
+			}
+			<-response.done
+			if !response.stored {
+				// the first request failed and was forgotten, try to process this one
+				continue
+			}
+			if debug {
+				log.Printf("IdempotencyHandler: replaying response for key %s", key)
+			}
+			for name, values := range response.header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header(IdempotencyReplayedHeader, "true")
+			c.Status(response.status)
+			if len(response.body) > 0 {
+				c.Writer.Write(response.body)
+			}
+			return
+		}
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer func() {
+			c.Writer = writer.ResponseWriter
+			cache.mutex.Lock()
+			if status := writer.Status(); status < http.StatusBadRequest && len(c.Errors) == 0 {
+				response.stored = true
+				response.status = status
+				response.header = writer.Header().Clone()
+				response.body = writer.body.Bytes()
+			} else if cache.responses[key] == response {
+				delete(cache.responses, key)
+			}
+			cache.mutex.Unlock()
+			close(response.done)
+		}()
+		h(c)
+	}
+}
@@ -0,0 +1,132 @@
+package handler_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/handler"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyHandler(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		calls  int
+		status = http.StatusOK
+	)
+	h := func(c *gin.Context) {
+		mutex.Lock()
+		calls++
+		code := status
+		mutex.Unlock()
+		// give concurrent requests a chance to arrive
+		time.Sleep(20 * time.Millisecond)
+		c.Header("X-Call", "first")
+		c.String(code, "processed")
+	}
+	router := gin.Default()
+	router.POST("test", handler.IdempotencyHandler(h, "Idempotency-Key", time.Minute, false))
+	send := func(key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+		if len(key) > 0 {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := send("payment-1")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "processed", w.Body.String())
+			assert.Equal(t, "first", w.Header().Get("X-Call"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+	w := send("payment-1")
+	assert.Equal(t, "true", w.Header().Get(handler.IdempotencyReplayedHeader))
+	assert.Equal(t, 1, calls)
+	send("payment-2")
+	send("")
+	send("")
+	assert.Equal(t, 4, calls)
+	// failures are not kept
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, send("payment-3").Code)
+	status = http.StatusOK
+	w = send("payment-3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(handler.IdempotencyReplayedHeader))
+	assert.Equal(t, 6, calls)
+}
+
+func TestIdempotencyHandlerMismatch(t *testing.T) {
+	calls := 0
+	router := gin.Default()
+	router.POST("test", handler.IdempotencyHandler(func(c *gin.Context) {
+		calls++
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	}, "Idempotency-Key", time.Minute, false))
+	send := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "http://localhost/test", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, "a=1", send("a=1").Body.String())
+	assert.Equal(t, http.StatusUnprocessableEntity, send("a=2").Code)
+	assert.Equal(t, "a=1", send("a=1").Body.String())
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotencyHandlerFailedSave(t *testing.T) {
+	calls := 0
+	router := gin.Default()
+	router.POST("test", handler.IdempotencyHandler(func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.Error(errors.New("disk full"))
+		}
+		c.Status(http.StatusOK)
+	}, "Idempotency-Key", time.Minute, false))
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+		req.Header.Set("Idempotency-Key", "key")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyHandlerExpiry(t *testing.T) {
+	calls := 0
+	router := gin.Default()
+	router.POST("test", handler.IdempotencyHandler(func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusOK)
+	}, "Idempotency-Key", 20*time.Millisecond, false))
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+		req.Header.Set("Idempotency-Key", "key")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, 1, calls)
+	time.Sleep(30 * time.Millisecond)
+	req, _ := http.NewRequest("POST", "http://localhost/test", nil)
+	req.Header.Set("Idempotency-Key", "key")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 2, calls)
+}
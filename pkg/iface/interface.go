@@ -10,13 +10,43 @@ import (
 // PayloadRecord holds data related to a single request in a parsed form.
 type PayloadRecord struct {
 	// usually the string data of the request
-	Payload string
+	Payload string `json:"payload"`
 	// timestamp at the time when the payload was created (not request received)
-	Timestamp time.Time
+	Timestamp time.Time `json:"timestamp"`
 	// meta information, things like content-type and so on.
-	Meta map[string][]string
+	Meta map[string][]string `json:"meta"`
 	// remote address if provided (may be completely wrong if behind firewalls, proxies etc.)
-	Remote string
+	Remote string `json:"remote"`
+	// HTTP method of the request
+	Method string `json:"method,omitempty"`
+	// URL of the request as seen by glutton
+	URL string `json:"url,omitempty"`
+	// how long receiving the request body took, zero if unknown
+	Received time.Duration `json:"received,omitempty"`
+	// response of the upstream the payload was forwarded to, if any
+	Upstream *UpstreamResponse `json:"upstream,omitempty"`
+	// set if the payload is a duplicate of one already stored
+	Duplicate *Duplicate `json:"duplicate,omitempty"`
+}
+
+// Duplicate references the stored payload a duplicate is identical to.
+type Duplicate struct {
+	Hash      string    `json:"hash"`
+	FirstSeen time.Time `json:"first_seen"`
+	// the body stripped from the payload, never stored but passed on by savers forwarding the payload
+	Body string `json:"-"`
+}
+
+// UpstreamResponse describes the outcome of forwarding a payload.
+type UpstreamResponse struct {
+	URL      string              `json:"url"`
+	Status   int                 `json:"status,omitempty"`
+	Header   map[string][]string `json:"header,omitempty"`
+	Body     string              `json:"body,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	Attempts int                 `json:"attempts"`
+	// how long the last attempt took
+	Duration time.Duration `json:"duration,omitempty"`
 }
 
 func (p *PayloadRecord) String() string {
@@ -28,6 +58,15 @@ func (p *PayloadRecord) String() string {
 	builder.WriteString(p.Payload)
 	builder.WriteString("\n\n")
 	builder.WriteString(fmt.Sprintf("%+v\n", p.Meta))
+	if p.Duplicate != nil {
+		builder.WriteString(fmt.Sprintf("\nduplicate of %s first seen %s\n", p.Duplicate.Hash, p.Duplicate.FirstSeen))
+	}
+	if p.Upstream != nil {
+		builder.WriteString(fmt.Sprintf("\nforwarded to %s after %d attempt(s): %d %s\n", p.Upstream.URL, p.Upstream.Attempts, p.Upstream.Status, p.Upstream.Error))
+		builder.WriteString(fmt.Sprintf("%+v\n\n", p.Upstream.Header))
+		builder.WriteString(p.Upstream.Body)
+		builder.WriteString("\n")
+	}
 	return builder.String()
 }
 
@@ -53,3 +92,31 @@ type PayloadNotifier interface {
 	Configurable
 	Notify(*PayloadRecord) error
 }
+
+// PayloadFilter inspects payload before it is notified of and saved (e.g. to drop duplicates). It returns false if the payload is to be dropped, it may alter the payload too.
+type PayloadFilter interface {
+	Configurable
+	Filter(*PayloadRecord) bool
+}
+
+// PayloadCommitter is a filter told whether the payload it let through was saved (e.g. to remember saved payload only).
+type PayloadCommitter interface {
+	Commit(payload *PayloadRecord, saved bool)
+}
+
+// PartialSaveError is an error of a saver that stored the payload nonetheless (e.g. a copy failed to be saved while the primary saver succeeded).
+type PartialSaveError interface {
+	error
+	Saved() bool
+}
+
+// HealthChecker is anything that can report its health (e.g. a saver holding a database connection).
+type HealthChecker interface {
+	Health() error
+}
+
+// HealthReporter is a HealthChecker telling more than ok when healthy (e.g. the depth of a queue).
+type HealthReporter interface {
+	HealthChecker
+	HealthStatus() string
+}
@@ -0,0 +1,276 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/seal"
+)
+
+const (
+	// OverflowDrop drops notifications the queue has no room for.
+	OverflowDrop = "drop"
+	// OverflowBlock makes the request wait for room in the queue.
+	OverflowBlock = "block"
+	// OverflowSpill writes notifications the queue has no room for to disk, they are queued once there is room.
+	OverflowSpill = "spill"
+	// spillSuffix ends names of spilled notifications.
+	spillSuffix = ".json"
+	// spillPoll is how often spilled notifications are looked for, besides when some are spilled.
+	spillPoll = 5 * time.Second
+)
+
+// AsyncNotifier runs the wrapped notifier off the request path, in a pool of workers fed by a bounded queue. Notifications are queued in the order they come, spilled ones included.
+type AsyncNotifier struct {
+	notifier iface.PayloadNotifier
+	route    string
+	overflow string
+	spillDir string
+	sealer   *seal.Sealer
+	opener   *seal.Opener
+	queue    chan *iface.PayloadRecord
+	// backlog counts spilled notifications not queued yet, new ones are spilled behind them
+	backlog int64
+	// mutex guards closed, notifications hold it for reading while queueing
+	mutex   sync.RWMutex
+	closed  bool
+	spilled chan struct{}
+	done    chan struct{}
+	feeding sync.WaitGroup
+	working sync.WaitGroup
+	dropped int64
+	failed  int64
+}
+
+// NewAsyncNotifier wraps the notifier into an AsyncNotifier and starts its workers. Notifications spilled to spillDir by a previous run are queued again. Spilled notifications are sealed with the master key if set, they are plain JSON otherwise.
+func NewAsyncNotifier(notifier iface.PayloadNotifier, route string, workers, queueSize int, overflow, spillDir, masterKey string) (*AsyncNotifier, error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	a := &AsyncNotifier{
+		notifier: notifier,
+		route:    route,
+		overflow: overflow,
+		spillDir: spillDir,
+		queue:    make(chan *iface.PayloadRecord, queueSize),
+		spilled:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	switch overflow {
+	case OverflowDrop, OverflowBlock:
+	case OverflowSpill:
+		if len(spillDir) == 0 {
+			return nil, errors.New("spill directory not set")
+		}
+		if err := os.MkdirAll(spillDir, 0700); err != nil {
+			return nil, errors.Wrapf(err, "error creating spill directory %s", spillDir)
+		}
+		if len(masterKey) > 0 {
+			var err error
+			if a.sealer, err = seal.NewSealer(masterKey, ""); err != nil {
+				return nil, errors.Wrap(err, "error configuring sealing of spilled notifications")
+			}
+			if a.opener, err = seal.NewOpener(masterKey, nil); err != nil {
+				return nil, errors.Wrap(err, "error configuring sealing of spilled notifications")
+			}
+		}
+		a.backlog = int64(len(a.spilledFiles()))
+		a.feeding.Add(1)
+		go a.feed()
+	default:
+		return nil, errors.Errorf("unknown overflow policy %s", overflow)
+	}
+	a.working.Add(workers)
+	for i := 0; i < workers; i++ {
+		go a.work()
+	}
+	return a, nil
+}
+
+// Configure does nothing, the wrapped notifier is expected to be configured already.
+func (a *AsyncNotifier) Configure(*iface.Settings) error {
+	return nil
+}
+
+// Notify queues a copy of the payload (savers may alter the payload meanwhile). If the queue is full the notification is dropped (an error is returned), spilled or it waits, as the overflow policy says. While spilled notifications wait, new ones are spilled too.
+func (a *AsyncNotifier) Notify(payload *iface.PayloadRecord) error {
+	if payload == nil {
+		return nil
+	}
+	copied := *payload
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.closed {
+		return errors.New("notifier closed")
+	}
+	if a.overflow == OverflowSpill && atomic.LoadInt64(&a.backlog) > 0 {
+		return a.spill(&copied)
+	}
+	select {
+	case a.queue <- &copied:
+		return nil
+	default:
+	}
+	switch a.overflow {
+	case OverflowBlock:
+		a.queue <- &copied
+		return nil
+	case OverflowSpill:
+		return a.spill(&copied)
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+		return errors.Errorf("notification queue full (%d), notification dropped", cap(a.queue))
+	}
+}
+
+// Health fails if notifications can't be spilled, a full queue is reported by HealthStatus only.
+func (a *AsyncNotifier) Health() error {
+	if a.overflow != OverflowSpill {
+		return nil
+	}
+	probe, err := ioutil.TempFile(a.spillDir, "health-*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "spill directory %s not writable", a.spillDir)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// HealthStatus reports depth of the queue along with the number of spilled, dropped and failed notifications.
+func (a *AsyncNotifier) HealthStatus() string {
+	status := fmt.Sprintf("ok, queued %d/%d", len(a.queue), cap(a.queue))
+	if a.overflow == OverflowSpill {
+		status += fmt.Sprintf(", spilled %d", len(a.spilledFiles()))
+	}
+	return status + fmt.Sprintf(", dropped %d, failed %d", atomic.LoadInt64(&a.dropped), atomic.LoadInt64(&a.failed))
+}
+
+// Close stops accepting notifications and waits for the queued ones to be sent. Spilled notifications not queued yet stay on disk for the next run.
+func (a *AsyncNotifier) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mutex.Unlock()
+	close(a.done)
+	a.feeding.Wait()
+	close(a.queue)
+	a.working.Wait()
+	return nil
+}
+
+func (a *AsyncNotifier) work() {
+	defer a.working.Done()
+	for payload := range a.queue {
+		if err := a.notifier.Notify(payload); err != nil {
+			atomic.AddInt64(&a.failed, 1)
+			log.Printf("%s: error notifying of payload %+v", a.route, err)
+		}
+	}
+}
+
+// spill writes the payload (sealed if configured) to the spill directory and wakes the feeder up.
+func (a *AsyncNotifier) spill(payload *iface.PayloadRecord) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "error encoding spilled notification")
+	}
+	if a.sealer != nil {
+		sealed, err := a.sealer.Seal(data)
+		if err != nil {
+			return errors.Wrap(err, "error sealing spilled notification")
+		}
+		data = []byte(sealed)
+	}
+	// names sort by the time of spilling
+	name := filepath.Join(a.spillDir, fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), uuid.NewString(), spillSuffix))
+	if err = ioutil.WriteFile(name+".tmp", data, 0600); err != nil {
+		return errors.Wrapf(err, "error spilling notification to %s", name)
+	}
+	if err = os.Rename(name+".tmp", name); err != nil {
+		return errors.Wrapf(err, "error spilling notification to %s", name)
+	}
+	atomic.AddInt64(&a.backlog, 1)
+	select {
+	case a.spilled <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// spilledFiles lists spilled notifications, oldest first.
+func (a *AsyncNotifier) spilledFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(a.spillDir, "*"+spillSuffix))
+	sort.Strings(files)
+	return files
+}
+
+// feed queues spilled notifications, waiting for room in the queue. A file is removed once its notification is queued.
+func (a *AsyncNotifier) feed() {
+	defer a.feeding.Done()
+	ticker := time.NewTicker(spillPoll)
+	defer ticker.Stop()
+	for {
+		for _, file := range a.spilledFiles() {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				log.Printf("%s: error reading spilled notification %+v", a.route, err)
+				continue
+			}
+			payload, err := a.decode(data)
+			if err != nil {
+				log.Printf("%s: error decoding spilled notification %s %+v", a.route, file, err)
+				os.Rename(file, strings.TrimSuffix(file, spillSuffix)+".invalid")
+				atomic.AddInt64(&a.backlog, -1)
+				continue
+			}
+			select {
+			case a.queue <- payload:
+				os.Remove(file)
+				atomic.AddInt64(&a.backlog, -1)
+			case <-a.done:
+				return
+			}
+		}
+		select {
+		case <-a.spilled:
+		case <-ticker.C:
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// decode reads a spilled notification, sealed ones are opened first.
+func (a *AsyncNotifier) decode(data []byte) (*iface.PayloadRecord, error) {
+	if seal.IsSealed(string(data)) {
+		if a.opener == nil {
+			return nil, errors.New("notification sealed, master key not set")
+		}
+		opened, err := a.opener.Open(string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = opened
+	}
+	payload := &iface.PayloadRecord{}
+	return payload, errors.Wrap(json.Unmarshal(data, payload), "error decoding notification")
+}
@@ -0,0 +1,157 @@
+package notifier
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingNotifier waits for release before notifying.
+type blockingNotifier struct {
+	recordingNotifier
+	release chan struct{}
+}
+
+func (b *blockingNotifier) Notify(payload *iface.PayloadRecord) error {
+	<-b.release
+	return b.recordingNotifier.Notify(payload)
+}
+
+func TestAsyncNotifier_Drop(t *testing.T) {
+	blocking := &blockingNotifier{release: make(chan struct{})}
+	a, err := NewAsyncNotifier(blocking, "/contact", 1, 2, OverflowDrop, "", "")
+	assert.NoError(t, err)
+	payload := &iface.PayloadRecord{Payload: "first"}
+	assert.NoError(t, a.Notify(payload))
+	// the queue holds a copy
+	payload.Payload = "changed"
+	// wait for the worker to take the first one
+	assert.Eventually(t, func() bool { return len(a.queue) == 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "second"}))
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "third"}))
+	// a full queue isn't broken
+	assert.NoError(t, a.Health())
+	assert.Error(t, a.Notify(&iface.PayloadRecord{Payload: "fourth"}))
+	assert.Equal(t, "ok, queued 2/2, dropped 1, failed 0", a.HealthStatus())
+	close(blocking.release)
+	assert.NoError(t, a.Close())
+	assert.NoError(t, a.Health())
+	notified := blocking.notified()
+	assert.Len(t, notified, 3)
+	assert.Equal(t, "first", notified[0].Payload)
+	assert.Error(t, a.Notify(&iface.PayloadRecord{Payload: "fifth"}))
+	assert.NoError(t, a.Close())
+}
+
+func TestAsyncNotifier_Block(t *testing.T) {
+	recorder := &recordingNotifier{}
+	a, err := NewAsyncNotifier(recorder, "/contact", 3, 1, OverflowBlock, "", "")
+	assert.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: fmt.Sprint(i)}))
+	}
+	assert.NoError(t, a.Close())
+	assert.Len(t, recorder.notified(), 50)
+}
+
+func TestAsyncNotifier_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	blocking := &blockingNotifier{release: make(chan struct{})}
+	a, err := NewAsyncNotifier(blocking, "/contact", 1, 1, OverflowSpill, dir, "")
+	assert.NoError(t, err)
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "first"}))
+	assert.Eventually(t, func() bool { return len(a.queue) == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: fmt.Sprint("spilled ", i)}))
+	}
+	// one queued, the feeder waits with another one, the rest is on disk
+	assert.Eventually(t, func() bool { return len(a.spilledFiles()) == 3 && len(a.queue) == 1 }, time.Second, time.Millisecond)
+	assert.Contains(t, a.HealthStatus(), "spilled 3")
+	assert.NoError(t, a.Health())
+	close(blocking.release)
+	assert.NoError(t, a.Close())
+	notified := blocking.notified()
+	assert.Len(t, notified, 5-len(a.spilledFiles()))
+	for i, payload := range notified[1:] {
+		assert.Equal(t, fmt.Sprint("spilled ", i), payload.Payload)
+	}
+}
+
+func TestAsyncNotifier_SpillOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	previous := &AsyncNotifier{spillDir: dir, spilled: make(chan struct{}, 1)}
+	assert.NoError(t, previous.spill(&iface.PayloadRecord{Payload: "spilled"}))
+	blocking := &blockingNotifier{release: make(chan struct{})}
+	a, err := NewAsyncNotifier(blocking, "/contact", 1, 10, OverflowSpill, dir, "")
+	assert.NoError(t, err)
+	// there's room in the queue, but the spilled one goes first
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "new"}))
+	close(blocking.release)
+	assert.Eventually(t, func() bool { return len(blocking.notified()) == 2 }, time.Second, time.Millisecond)
+	assert.NoError(t, a.Close())
+	assert.Equal(t, "spilled", blocking.notified()[0].Payload)
+	assert.Equal(t, "new", blocking.notified()[1].Payload)
+}
+
+func TestAsyncNotifier_SpillSealed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	blocking := &blockingNotifier{release: make(chan struct{})}
+	a, err := NewAsyncNotifier(blocking, "/contact", 1, 1, OverflowSpill, dir, key)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "first"}))
+	assert.Eventually(t, func() bool { return len(a.queue) == 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "second"}))
+	assert.NoError(t, a.Notify(&iface.PayloadRecord{Payload: "email=john@example.com"}))
+	files := a.spilledFiles()
+	assert.Len(t, files, 1)
+	content, err := ioutil.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "john")
+	close(blocking.release)
+	assert.Eventually(t, func() bool { return len(blocking.notified()) == 3 }, time.Second, time.Millisecond)
+	assert.NoError(t, a.Close())
+	assert.Equal(t, "email=john@example.com", blocking.notified()[2].Payload)
+	_, err = NewAsyncNotifier(blocking, "/contact", 1, 0, OverflowSpill, dir, "short")
+	assert.Error(t, err)
+}
+
+func TestAsyncNotifier_SpilledOnStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	previous := &AsyncNotifier{spillDir: dir, spilled: make(chan struct{}, 1)}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, previous.spill(&iface.PayloadRecord{Payload: fmt.Sprint("spilled ", i)}))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600))
+	recorder := &recordingNotifier{}
+	a, err := NewAsyncNotifier(recorder, "/contact", 1, 1, OverflowSpill, dir, "")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(recorder.notified()) == 3 }, time.Second, time.Millisecond)
+	assert.NoError(t, a.Close())
+	assert.Empty(t, a.spilledFiles())
+	assert.FileExists(t, filepath.Join(dir, "broken.invalid"))
+	// spilled in order
+	assert.Equal(t, "spilled 0", recorder.notified()[0].Payload)
+}
+
+func TestNewAsyncNotifier(t *testing.T) {
+	_, err := NewAsyncNotifier(&recordingNotifier{}, "/contact", 1, 1, "explode", "", "")
+	assert.Error(t, err)
+	_, err = NewAsyncNotifier(&recordingNotifier{}, "/contact", 1, 1, OverflowSpill, "", "")
+	assert.Error(t, err)
+}
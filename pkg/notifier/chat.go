@@ -0,0 +1,207 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+// defaultChatTemplate summarizes the payload, listing form fields if there are any.
+const defaultChatTemplate = `New payload on {{.Route}} ({{.Name}}) from {{.Remote}} at {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+{{range $name, $values := .Fields}}{{$name}}: {{index $values 0}}
+{{else}}{{.Payload}}
+{{end}}`
+
+const (
+	// slackMaxLength is the length Slack recommends to keep messages under.
+	slackMaxLength = 4000
+	// teamsMaxLength keeps the card well under the 28 KB limit of Teams webhooks.
+	teamsMaxLength = 20000
+	// discordMaxLength is the limit of the message content.
+	discordMaxLength = 2000
+)
+
+// chatNotifier posts summaries of payload to a chat webhook, the concrete notifiers wrap the text as their service expects.
+type chatNotifier struct {
+	service   string
+	url       string
+	name      string
+	route     string
+	template  *template.Template
+	maxLength int
+	client    *http.Client
+}
+
+// configure sets the notifier up, maxLength applies unless ChatMaxLength is set.
+func (c *chatNotifier) configure(settings *iface.Settings, service, url string, maxLength int) (err error) {
+	if len(url) == 0 {
+		return errors.Errorf("%s url not set", service)
+	}
+	text := settings.ChatTemplate
+	if len(text) == 0 {
+		text = defaultChatTemplate
+	}
+	if c.template, err = template.New(service).Parse(text); err != nil {
+		return errors.Wrapf(err, "error parsing %s template", service)
+	}
+	timeout, err := iface.ParseDuration(settings.ChatTimeout, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	c.service = service
+	c.url = url
+	c.name = settings.Name
+	c.route = settings.URI
+	c.maxLength = maxLength
+	if settings.ChatMaxLength > 0 && settings.ChatMaxLength < maxLength {
+		c.maxLength = settings.ChatMaxLength
+	}
+	c.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// text renders the summary of the payload, truncated to the maximum length.
+func (c *chatNotifier) text(payload *iface.PayloadRecord) (string, error) {
+	text, err := execute(c.template, newMessageData(c.name, c.route, payload))
+	if err != nil {
+		return "", err
+	}
+	return truncate(strings.TrimSpace(text), c.maxLength), nil
+}
+
+// post sends the message encoded as JSON to the webhook.
+func (c *chatNotifier) post(message interface{}) error {
+	return c.send(http.MethodPost, c.url, nil, message)
+}
+
+// send sends the message encoded as JSON, header is added to the request.
+func (c *chatNotifier) send(method, url string, header http.Header, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrapf(err, "error encoding %s message", c.service)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	response, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error sending %s message", c.service)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+		return errors.Errorf("%s responded %s: %s", c.service, response.Status, detail)
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	return nil
+}
+
+// truncate shortens text to at most max characters, an ellipsis marks the cut.
+func truncate(text string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:max-1]) + "…"
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	chatNotifier
+}
+
+// Configure configures the SlackNotifier.
+// Namely the following params are used:
+// * SlackWebhookURL - the incoming webhook
+// * ChatTemplate, ChatMaxLength, ChatTimeout - template of the message, its maximum length and timeout of the request
+func (s *SlackNotifier) Configure(settings *iface.Settings) error {
+	return s.configure(settings, "slack", settings.SlackWebhookURL, slackMaxLength)
+}
+
+// slackEscaper escapes characters Slack treats as markup.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// Notify posts the summary of the payload.
+func (s *SlackNotifier) Notify(payload *iface.PayloadRecord) error {
+	text, err := s.text(payload)
+	if err != nil {
+		return err
+	}
+	return s.post(map[string]string{"text": slackEscaper.Replace(text)})
+}
+
+// TeamsNotifier posts notifications to a Microsoft Teams webhook (a workflow or a connector) as an adaptive card.
+type TeamsNotifier struct {
+	chatNotifier
+}
+
+// Configure configures the TeamsNotifier.
+// Namely the following params are used:
+// * TeamsWebhookURL - the webhook
+// * ChatTemplate, ChatMaxLength, ChatTimeout - template of the message, its maximum length and timeout of the request
+func (t *TeamsNotifier) Configure(settings *iface.Settings) error {
+	return t.configure(settings, "teams", settings.TeamsWebhookURL, teamsMaxLength)
+}
+
+// Notify posts the summary of the payload.
+func (t *TeamsNotifier) Notify(payload *iface.PayloadRecord) error {
+	text, err := t.text(payload)
+	if err != nil {
+		return err
+	}
+	// a text block ignores single line breaks
+	text = strings.ReplaceAll(text, "\n", "\n\n")
+	return t.post(map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    []interface{}{map[string]interface{}{"type": "TextBlock", "text": text, "wrap": true}},
+			},
+		}},
+	})
+}
+
+// DiscordNotifier posts notifications to a Discord webhook.
+type DiscordNotifier struct {
+	chatNotifier
+}
+
+// Configure configures the DiscordNotifier.
+// Namely the following params are used:
+// * DiscordWebhookURL - the webhook
+// * ChatTemplate, ChatMaxLength, ChatTimeout - template of the message, its maximum length and timeout of the request
+func (d *DiscordNotifier) Configure(settings *iface.Settings) error {
+	return d.configure(settings, "discord", settings.DiscordWebhookURL, discordMaxLength)
+}
+
+// Notify posts the summary of the payload. Mentions in the payload don't ping anyone.
+func (d *DiscordNotifier) Notify(payload *iface.PayloadRecord) error {
+	text, err := d.text(payload)
+	if err != nil {
+		return err
+	}
+	return d.post(map[string]interface{}{
+		"content":          text,
+		"username":         truncate(d.name, 80),
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	})
+}
@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// chatServer records JSON messages posted to it.
+func chatServer(t *testing.T, status int, messages *[]map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		message := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+		*messages = append(*messages, message)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+var chatPayload = &iface.PayloadRecord{
+	Payload:   "name=Jan&message=%3Cb%3E%40everyone%3C%2Fb%3E",
+	Meta:      map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}},
+	Remote:    "127.0.0.1:1234",
+	Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func TestSlackNotifier_Notify(t *testing.T) {
+	var messages []map[string]interface{}
+	server := chatServer(t, http.StatusOK, &messages)
+	n := new(SlackNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "contact", URI: "/contact", SlackWebhookURL: server.URL}))
+	assert.NoError(t, n.Notify(chatPayload))
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "New payload on /contact (contact) from 127.0.0.1:1234 at 2020-01-02 03:04:05 UTC\nmessage: &lt;b&gt;@everyone&lt;/b&gt;\nname: Jan", messages[0]["text"])
+}
+
+func TestTeamsNotifier_Notify(t *testing.T) {
+	var messages []map[string]interface{}
+	server := chatServer(t, http.StatusAccepted, &messages)
+	n := new(TeamsNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{TeamsWebhookURL: server.URL, ChatTemplate: `{{.Field "name"}} wrote`}))
+	assert.NoError(t, n.Notify(chatPayload))
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "message", messages[0]["type"])
+	card := messages[0]["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "application/vnd.microsoft.card.adaptive", card["contentType"])
+	block := card["content"].(map[string]interface{})["body"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Jan wrote", block["text"])
+}
+
+func TestDiscordNotifier_Notify(t *testing.T) {
+	var messages []map[string]interface{}
+	server := chatServer(t, http.StatusNoContent, &messages)
+	n := new(DiscordNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "contact", DiscordWebhookURL: server.URL, ChatMaxLength: 10}))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: strings.Repeat("ü", 100)}))
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "contact", messages[0]["username"])
+	assert.Equal(t, "New paylo…", messages[0]["content"])
+	assert.Equal(t, map[string]interface{}{"parse": []interface{}{}}, messages[0]["allowed_mentions"])
+}
+
+func TestChatNotifier_Errors(t *testing.T) {
+	var messages []map[string]interface{}
+	server := chatServer(t, http.StatusNotFound, &messages)
+	n := new(SlackNotifier)
+	assert.Error(t, n.Configure(&iface.Settings{}))
+	assert.Error(t, n.Configure(&iface.Settings{SlackWebhookURL: server.URL, ChatTemplate: "{{.Name"}))
+	assert.NoError(t, n.Configure(&iface.Settings{SlackWebhookURL: server.URL}))
+	assert.Error(t, n.Notify(chatPayload))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 5))
+	assert.Equal(t, "sho…", truncate("shorter", 4))
+	assert.Equal(t, "žlu…", truncate("žluťoučký", 4))
+	assert.Equal(t, "anything", truncate("anything", 0))
+}
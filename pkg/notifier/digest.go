@@ -0,0 +1,158 @@
+package notifier
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	// DigestHeader is set on digest records, it holds the number of records summarized.
+	DigestHeader = "X-Glutton-Digest"
+	// digestMaxListed limits the number of records listed in a digest.
+	digestMaxListed = 50
+	// digestMaxRecord limits the length of a single record in a digest.
+	digestMaxRecord = 1000
+	// digestMaxKept limits the number of records kept while digests fail to be sent.
+	digestMaxKept = 1000
+)
+
+// DigestNotifier collects payload and notifies of it in a single summary once size records are collected or interval elapses, whatever comes first. Records of a digest failed to be sent (or refused by a rate limit) are kept for the next one.
+type DigestNotifier struct {
+	notifier iface.PayloadNotifier
+	route    string
+	size     int
+	mutex    sync.Mutex
+	records  []*iface.PayloadRecord
+	done     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// NewDigestNotifier wraps the notifier into a DigestNotifier and starts its interval flushing (if the interval is positive). Errors of the interval flushing are only logged.
+func NewDigestNotifier(notifier iface.PayloadNotifier, route string, interval time.Duration, size int) *DigestNotifier {
+	d := &DigestNotifier{notifier: notifier, route: route, size: size, done: make(chan struct{})}
+	if interval > 0 {
+		d.stopped.Add(1)
+		go d.run(interval)
+	}
+	return d
+}
+
+// Configure does nothing, the wrapped notifier is expected to be configured already.
+func (d *DigestNotifier) Configure(*iface.Settings) error {
+	return nil
+}
+
+// Notify adds the payload to the digest, the digest is sent once full.
+func (d *DigestNotifier) Notify(payload *iface.PayloadRecord) error {
+	if payload == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	d.records = append(d.records, payload)
+	if d.size <= 0 || len(d.records) < d.size {
+		d.mutex.Unlock()
+		return nil
+	}
+	records := d.take()
+	d.mutex.Unlock()
+	return d.send(records)
+}
+
+// Flush sends the current digest, if there is anything in it.
+func (d *DigestNotifier) Flush() error {
+	d.mutex.Lock()
+	records := d.take()
+	d.mutex.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	return d.send(records)
+}
+
+// send notifies of the digest of records, they are put back if it fails or the rate limit refuses it.
+func (d *DigestNotifier) send(records []*iface.PayloadRecord) error {
+	err := d.notifier.Notify(digestRecord(d.route, records))
+	if err != nil {
+		d.restore(records)
+	}
+	if err == errRateLimited {
+		return nil
+	}
+	return err
+}
+
+// restore puts records back in front of those collected since, at most digestMaxKept records are kept, the oldest are dropped.
+func (d *DigestNotifier) restore(records []*iface.PayloadRecord) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.records = append(records, d.records...)
+	if dropped := len(d.records) - digestMaxKept; dropped > 0 {
+		log.Printf("%s: digest failed to be sent, dropping %d oldest payloads", d.route, dropped)
+		d.records = d.records[dropped:]
+	}
+}
+
+// Close stops the interval flushing and sends what's left.
+func (d *DigestNotifier) Close() error {
+	close(d.done)
+	d.stopped.Wait()
+	err := d.Flush()
+	d.mutex.Lock()
+	left := len(d.take())
+	d.mutex.Unlock()
+	if left > 0 && err == nil {
+		log.Printf("%s: rate limit reached, %d payloads of the digest not notified of", d.route, left)
+	}
+	return err
+}
+
+// take returns the current records and starts a new digest, must be called with the mutex held.
+func (d *DigestNotifier) take() []*iface.PayloadRecord {
+	records := d.records
+	d.records = nil
+	return records
+}
+
+func (d *DigestNotifier) run(interval time.Duration) {
+	defer d.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Flush(); err != nil {
+				log.Printf("%s: error sending digest %+v", d.route, err)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// digestRecord summarizes records as a single text record, only the first few records are listed.
+func digestRecord(route string, records []*iface.PayloadRecord) *iface.PayloadRecord {
+	var summary strings.Builder
+	first, last := records[0].Timestamp, records[len(records)-1].Timestamp
+	fmt.Fprintf(&summary, "%d payloads received on %s between %s and %s\n", len(records), route, first.Format(time.RFC3339), last.Format(time.RFC3339))
+	for i, record := range records {
+		if i == digestMaxListed {
+			fmt.Fprintf(&summary, "\n... and %d more\n", len(records)-i)
+			break
+		}
+		fmt.Fprintf(&summary, "\n--- %d ---\n%s\n", i+1, truncate(record.String(), digestMaxRecord))
+	}
+	return &iface.PayloadRecord{
+		Payload:   summary.String(),
+		Timestamp: time.Now(),
+		Meta: map[string][]string{
+			"Content-Type": {"text/plain; charset=utf-8"},
+			DigestHeader:   {fmt.Sprint(len(records))},
+		},
+		URL: route,
+	}
+}
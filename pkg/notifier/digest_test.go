@@ -0,0 +1,148 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingNotifier keeps what it's notified of.
+type recordingNotifier struct {
+	mutex    sync.Mutex
+	payloads []*iface.PayloadRecord
+}
+
+func (r *recordingNotifier) Configure(*iface.Settings) error {
+	return nil
+}
+
+func (r *recordingNotifier) Notify(payload *iface.PayloadRecord) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.payloads = append(r.payloads, payload)
+	return nil
+}
+
+func (r *recordingNotifier) notified() []*iface.PayloadRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*iface.PayloadRecord{}, r.payloads...)
+}
+
+func TestDigestNotifier_Size(t *testing.T) {
+	recorder := &recordingNotifier{}
+	d := NewDigestNotifier(recorder, "/contact", 0, 3)
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, d.Notify(&iface.PayloadRecord{Payload: fmt.Sprint("payload ", i), Timestamp: time.Now()}))
+	}
+	assert.Len(t, recorder.notified(), 2)
+	assert.NoError(t, d.Close())
+	notified := recorder.notified()
+	assert.Len(t, notified, 3)
+	assert.Equal(t, "3", notified[0].Meta[DigestHeader][0])
+	assert.Equal(t, "1", notified[2].Meta[DigestHeader][0])
+	assert.True(t, strings.HasPrefix(notified[0].Payload, "3 payloads received on /contact"))
+	assert.Contains(t, notified[0].Payload, "payload 2")
+	assert.NotContains(t, notified[0].Payload, "payload 3")
+	assert.Contains(t, notified[2].Payload, "payload 6")
+}
+
+func TestDigestNotifier_Interval(t *testing.T) {
+	recorder := &recordingNotifier{}
+	d := NewDigestNotifier(recorder, "/contact", 10*time.Millisecond, 0)
+	for i := 0; i < digestMaxListed+5; i++ {
+		assert.NoError(t, d.Notify(&iface.PayloadRecord{Payload: strings.Repeat("x", 2*digestMaxRecord), Timestamp: time.Now()}))
+	}
+	assert.Eventually(t, func() bool { return len(recorder.notified()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, d.Close())
+	notified := recorder.notified()
+	assert.Len(t, notified, 1)
+	assert.Contains(t, notified[0].Payload, "... and 5 more")
+	assert.Less(t, len(notified[0].Payload), (digestMaxListed+1)*(digestMaxRecord+20))
+}
+
+func TestRateLimitNotifier_Notify(t *testing.T) {
+	recorder := &recordingNotifier{}
+	r := NewRateLimitNotifier(recorder, "/contact", 2, time.Minute)
+	now := time.Now()
+	assert.True(t, r.allow(now))
+	assert.True(t, r.allow(now.Add(10*time.Second)))
+	assert.False(t, r.allow(now.Add(20*time.Second)))
+	assert.False(t, r.allow(now.Add(59*time.Second)))
+	assert.Equal(t, 2, r.budget.dropped)
+	// the first one is out of the window
+	assert.True(t, r.allow(now.Add(time.Minute)))
+	assert.Equal(t, 0, r.budget.dropped)
+	assert.False(t, r.allow(now.Add(time.Minute+5*time.Second)))
+	assert.True(t, r.allow(now.Add(time.Minute+10*time.Second)))
+	r = NewRateLimitNotifier(recorder, "/contact", 1, time.Hour)
+	assert.NoError(t, r.Notify(&iface.PayloadRecord{Payload: "first"}))
+	assert.NoError(t, r.Notify(&iface.PayloadRecord{Payload: "second"}))
+	notified := recorder.notified()
+	assert.Len(t, notified, 1)
+	assert.Equal(t, "first", notified[0].Payload)
+	// notifiers sharing the limit draw on the same budget
+	other := &recordingNotifier{}
+	assert.NoError(t, r.Share(other).Notify(&iface.PayloadRecord{Payload: "third"}))
+	assert.Empty(t, other.notified())
+}
+
+func TestDigestNotifier_RateLimit(t *testing.T) {
+	recorder := &recordingNotifier{}
+	d := NewDigestNotifier(NewRateLimitNotifier(recorder, "/contact", 1, 50*time.Millisecond), "/contact", 0, 2)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, d.Notify(&iface.PayloadRecord{Payload: fmt.Sprint("payload ", i), Timestamp: time.Now()}))
+	}
+	// the second digest is over the limit, its payload waits for the next one
+	assert.Len(t, recorder.notified(), 1)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, d.Notify(&iface.PayloadRecord{Payload: "payload 4", Timestamp: time.Now()}))
+	assert.NoError(t, d.Close())
+	notified := recorder.notified()
+	assert.Len(t, notified, 2)
+	assert.Equal(t, "3", notified[1].Meta[DigestHeader][0])
+	assert.Contains(t, notified[1].Payload, "payload 2")
+	assert.Contains(t, notified[1].Payload, "payload 4")
+}
+
+// flakyNotifier fails while failing is set, records otherwise.
+type flakyNotifier struct {
+	recordingNotifier
+	failing bool
+}
+
+func (f *flakyNotifier) Notify(payload *iface.PayloadRecord) error {
+	if f.failing {
+		return errors.New("smtp down")
+	}
+	return f.recordingNotifier.Notify(payload)
+}
+
+func TestDigestNotifier_Failed(t *testing.T) {
+	notifier := &flakyNotifier{failing: true}
+	d := NewDigestNotifier(notifier, "/contact", 0, 2)
+	assert.NoError(t, d.Notify(&iface.PayloadRecord{Payload: "payload 0", Timestamp: time.Now()}))
+	assert.Error(t, d.Notify(&iface.PayloadRecord{Payload: "payload 1", Timestamp: time.Now()}))
+	notifier.failing = false
+	assert.NoError(t, d.Notify(&iface.PayloadRecord{Payload: "payload 2", Timestamp: time.Now()}))
+	notified := notifier.notified()
+	assert.Len(t, notified, 1)
+	assert.Equal(t, "3", notified[0].Meta[DigestHeader][0])
+	assert.Contains(t, notified[0].Payload, "payload 0")
+	// at most digestMaxKept are kept
+	notifier.failing = true
+	for i := 0; i < digestMaxKept+10; i++ {
+		d.Notify(&iface.PayloadRecord{Payload: fmt.Sprint("payload ", i), Timestamp: time.Now()})
+	}
+	assert.Len(t, d.records, digestMaxKept)
+	assert.Equal(t, "payload 10", d.records[0].Payload)
+	notifier.failing = false
+	assert.NoError(t, d.Close())
+	assert.Equal(t, fmt.Sprint(digestMaxKept), notifier.notified()[1].Meta[DigestHeader][0])
+}
@@ -0,0 +1,291 @@
+package notifier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	defaultPagerDutyURL     = "https://events.pagerduty.com/v2/enqueue"
+	defaultIncidentSummary  = "Alert from {{.Name}} on {{.Route}}"
+	defaultIncidentResolve  = "json:status=(?i)^resolved$"
+	defaultIncidentSeverity = "error"
+	// pagerDutyMaxSummary and pagerDutyMaxKey are limits of the Events API v2.
+	pagerDutyMaxSummary = 1024
+	pagerDutyMaxKey     = 255
+	// incidentMaxLength limits the description of an incident.
+	incidentMaxLength = 10000
+)
+
+// incidentSeverities maps common severity names to those of PagerDuty.
+var incidentSeverities = map[string]string{
+	"critical": "critical", "crit": "critical", "fatal": "critical", "emergency": "critical", "p1": "critical",
+	"error": "error", "err": "error", "high": "error", "major": "error", "p2": "error",
+	"warning": "warning", "warn": "warning", "medium": "warning", "minor": "warning", "p3": "warning",
+	"info": "info", "low": "info", "notice": "info", "p4": "info", "p5": "info",
+}
+
+// incident is an event of an incident derived from a payload.
+type incident struct {
+	key         string
+	summary     string
+	description string
+	severity    string
+	source      string
+	resolved    bool
+	timestamp   time.Time
+	details     map[string]string
+}
+
+// incidentNotifier turns payload into incidents, keyed by values of the payload so that a later payload may resolve them. Without dedup key the payload itself is the key, so nothing resolves an incident.
+type incidentNotifier struct {
+	chatNotifier
+	summary         *template.Template
+	keys            []*selector
+	resolve         *condition
+	severity        *selector
+	defaultSeverity string
+}
+
+func (i *incidentNotifier) configureIncident(settings *iface.Settings, service, url string) (err error) {
+	if err = i.configure(settings, service, url, incidentMaxLength); err != nil {
+		return err
+	}
+	summary := settings.IncidentSummary
+	if len(summary) == 0 {
+		summary = defaultIncidentSummary
+	}
+	if i.summary, err = template.New(service + " summary").Parse(summary); err != nil {
+		return errors.Wrapf(err, "error parsing %s summary template", service)
+	}
+	i.keys = nil
+	for _, key := range settings.IncidentDedupKey {
+		s, err := parseSelector(key)
+		if err != nil {
+			return errors.Wrap(err, "error parsing incident dedup key")
+		}
+		i.keys = append(i.keys, s)
+	}
+	resolve := settings.IncidentResolve
+	if len(resolve) == 0 {
+		resolve = defaultIncidentResolve
+	}
+	if i.resolve, err = parseCondition(resolve); err != nil {
+		return errors.Wrap(err, "error parsing incident resolve condition")
+	}
+	if len(i.keys) == 0 {
+		if len(settings.IncidentResolve) > 0 {
+			return errors.New("incident resolve condition needs an incident dedup key")
+		}
+		i.resolve = nil
+	}
+	i.severity = nil
+	if len(settings.IncidentSeverityField) > 0 {
+		if i.severity, err = parseSelector(settings.IncidentSeverityField); err != nil {
+			return errors.Wrap(err, "error parsing incident severity field")
+		}
+	}
+	i.defaultSeverity = defaultIncidentSeverity
+	if len(settings.IncidentSeverity) > 0 {
+		if i.defaultSeverity = incidentSeverities[strings.ToLower(settings.IncidentSeverity)]; len(i.defaultSeverity) == 0 {
+			return errors.Errorf("unknown incident severity %s", settings.IncidentSeverity)
+		}
+	}
+	return nil
+}
+
+// incident derives the incident event of the payload.
+func (i *incidentNotifier) incident(payload *iface.PayloadRecord) (*incident, error) {
+	data := &ruleData{payload: payload}
+	messageData := newMessageData(i.name, i.route, payload)
+	summary, err := execute(i.summary, messageData)
+	if err != nil {
+		return nil, err
+	}
+	description, err := i.text(payload)
+	if err != nil {
+		return nil, err
+	}
+	event := &incident{
+		key:         i.key(payload, data),
+		summary:     truncate(strings.TrimSpace(summary), pagerDutyMaxSummary),
+		description: description,
+		severity:    i.defaultSeverity,
+		source:      i.name,
+		resolved:    i.resolve != nil && i.resolve.matches(payload, data),
+		timestamp:   payload.Timestamp,
+		details:     map[string]string{},
+	}
+	if host, _, err := net.SplitHostPort(payload.Remote); err == nil {
+		event.source = host
+	}
+	if event.timestamp.IsZero() {
+		event.timestamp = time.Now()
+	}
+	if i.severity != nil {
+		for _, value := range i.severity.values(payload, data) {
+			if severity, found := incidentSeverities[strings.ToLower(strings.TrimSpace(value))]; found {
+				event.severity = severity
+				break
+			}
+		}
+	}
+	for name, values := range messageData.Fields {
+		event.details[name] = strings.Join(values, ", ")
+	}
+	if len(event.details) == 0 {
+		event.details["payload"] = truncate(payload.Payload, incidentMaxLength)
+	}
+	return event, nil
+}
+
+// key joins values of the dedup key selectors, the payload is hashed if there are none.
+func (i *incidentNotifier) key(payload *iface.PayloadRecord, data *ruleData) string {
+	var parts []string
+	for _, s := range i.keys {
+		parts = append(parts, s.values(payload, data)...)
+	}
+	if len(parts) == 0 {
+		sum := sha256.Sum256([]byte(payload.Payload))
+		return hex.EncodeToString(sum[:16])
+	}
+	return truncate(i.route+"/"+strings.Join(parts, "/"), pagerDutyMaxKey)
+}
+
+// PagerDutyNotifier triggers (and resolves) PagerDuty incidents using the Events API v2.
+type PagerDutyNotifier struct {
+	incidentNotifier
+	routingKey string
+}
+
+// Configure configures the PagerDutyNotifier.
+// Namely the following params are used:
+// * PagerDutyRoutingKey - integration key of the service
+// * PagerDutyURL - the events endpoint
+// * IncidentDedupKey, IncidentResolve - selectors of the values identifying an incident and condition of resolved payload, resolving needs the key
+// * IncidentSummary, IncidentSeverity, IncidentSeverityField - summary template and severity of incidents
+// * ChatTemplate, ChatTimeout - template of the description and timeout of the request
+func (p *PagerDutyNotifier) Configure(settings *iface.Settings) error {
+	if len(settings.PagerDutyRoutingKey) == 0 {
+		return errors.New("pagerduty routing key not set")
+	}
+	p.routingKey = settings.PagerDutyRoutingKey
+	url := settings.PagerDutyURL
+	if len(url) == 0 {
+		url = defaultPagerDutyURL
+	}
+	return p.configureIncident(settings, "pagerduty", url)
+}
+
+// Notify sends a trigger event, or a resolve event if the payload says the incident is resolved.
+func (p *PagerDutyNotifier) Notify(payload *iface.PayloadRecord) error {
+	if payload == nil {
+		return nil
+	}
+	event, err := p.incident(payload)
+	if err != nil {
+		return err
+	}
+	message := map[string]interface{}{"routing_key": p.routingKey, "dedup_key": event.key, "event_action": "resolve"}
+	if !event.resolved {
+		message["event_action"] = "trigger"
+		details := map[string]interface{}{"description": event.description}
+		for name, value := range event.details {
+			details[name] = value
+		}
+		message["payload"] = map[string]interface{}{
+			"summary":        event.summary,
+			"source":         event.source,
+			"severity":       event.severity,
+			"timestamp":      event.timestamp.Format(time.RFC3339),
+			"component":      p.route,
+			"custom_details": details,
+		}
+	}
+	return p.send(http.MethodPost, p.url, nil, message)
+}
+
+// AlertmanagerNotifier posts alerts to Prometheus Alertmanager, to all instances of a cluster. Alerts are identified by their labels, the static ones and the dedup key, anything else goes to annotations.
+type AlertmanagerNotifier struct {
+	incidentNotifier
+	urls         []string
+	labels       map[string]string
+	resolveAfter time.Duration
+}
+
+// Configure configures the AlertmanagerNotifier.
+// Namely the following params are used:
+// * AlertmanagerURLs - the Alertmanager instances
+// * AlertmanagerLabels - additional labels of the alerts (`name=value` items)
+// * AlertmanagerResolveAfter - how long alerts fire unless resolved
+// * IncidentDedupKey, IncidentResolve - selectors of the values identifying an incident and condition of resolved payload, resolving needs the key
+// * IncidentSummary, IncidentSeverity, IncidentSeverityField - summary template and severity of incidents
+// * ChatTemplate, ChatTimeout - template of the description and timeout of the request
+func (a *AlertmanagerNotifier) Configure(settings *iface.Settings) (err error) {
+	if len(settings.AlertmanagerURLs) == 0 {
+		return errors.New("alertmanager urls not set")
+	}
+	if a.resolveAfter, err = iface.ParseDuration(settings.AlertmanagerResolveAfter, 24*time.Hour); err != nil {
+		return err
+	}
+	a.labels = map[string]string{"alertname": settings.Name, "route": settings.URI}
+	for _, label := range settings.AlertmanagerLabels {
+		i := strings.Index(label, "=")
+		if i <= 0 {
+			return errors.Errorf("alertmanager label %s isn't name=value", label)
+		}
+		a.labels[label[:i]] = label[i+1:]
+	}
+	a.urls = nil
+	for _, url := range settings.AlertmanagerURLs {
+		a.urls = append(a.urls, strings.TrimRight(url, "/")+"/api/v2/alerts")
+	}
+	return a.configureIncident(settings, "alertmanager", a.urls[0])
+}
+
+// Notify posts the alert to every instance, a resolved alert ends now. It fails only if no instance accepted the alert.
+func (a *AlertmanagerNotifier) Notify(payload *iface.PayloadRecord) error {
+	if payload == nil {
+		return nil
+	}
+	event, err := a.incident(payload)
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{"dedup_key": event.key}
+	for name, value := range a.labels {
+		labels[name] = value
+	}
+	startsAt, endsAt := event.timestamp, event.timestamp.Add(a.resolveAfter)
+	if event.resolved {
+		endsAt = time.Now()
+		if startsAt.After(endsAt) {
+			startsAt = endsAt
+		}
+	}
+	alert := map[string]interface{}{
+		"labels":      labels,
+		"annotations": map[string]string{"summary": event.summary, "description": event.description, "severity": event.severity},
+		"startsAt":    startsAt.UTC().Format(time.RFC3339),
+		"endsAt":      endsAt.UTC().Format(time.RFC3339),
+	}
+	var messages []string
+	for _, url := range a.urls {
+		if err := a.send(http.MethodPost, url, nil, []interface{}{alert}); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) == len(a.urls) {
+		return errors.Errorf("error posting alert: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
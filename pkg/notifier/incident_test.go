@@ -0,0 +1,103 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func monitorPayload(status, severity string) *iface.PayloadRecord {
+	return &iface.PayloadRecord{
+		Payload:   `{"id": "disk-42", "status": "` + status + `", "severity": "` + severity + `", "summary": "disk full"}`,
+		Meta:      map[string][]string{"Content-Type": {"application/json"}},
+		Remote:    "10.0.0.1:4321",
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestPagerDutyNotifier_Notify(t *testing.T) {
+	var requests []pushRequest
+	server := pushServer(t, &requests)
+	n := new(PagerDutyNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "monitor", URI: "/alerts", PagerDutyRoutingKey: "R0UT1NG", PagerDutyURL: server.URL,
+		IncidentDedupKey: []string{"json:id"}, IncidentSeverityField: "json:severity", IncidentSummary: `{{.Field "summary"}}`}))
+	assert.NoError(t, n.Notify(monitorPayload("firing", "Crit")))
+	assert.NoError(t, n.Notify(monitorPayload("resolved", "info")))
+	assert.Len(t, requests, 2)
+	trigger := requests[0].body
+	assert.Equal(t, "R0UT1NG", trigger["routing_key"])
+	assert.Equal(t, "trigger", trigger["event_action"])
+	assert.Equal(t, "/alerts/disk-42", trigger["dedup_key"])
+	event := trigger["payload"].(map[string]interface{})
+	assert.Equal(t, "disk full", event["summary"])
+	assert.Equal(t, "critical", event["severity"])
+	assert.Equal(t, "10.0.0.1", event["source"])
+	assert.Equal(t, "2020-01-02T03:04:05Z", event["timestamp"])
+	assert.Equal(t, "disk-42", event["custom_details"].(map[string]interface{})["id"])
+	assert.Equal(t, map[string]interface{}{"routing_key": "R0UT1NG", "dedup_key": "/alerts/disk-42", "event_action": "resolve"}, requests[1].body)
+	// without dedup key the payload is hashed
+	assert.NoError(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG", PagerDutyURL: server.URL, IncidentSeverity: "warning"}))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "plain text alert"}))
+	assert.Len(t, requests[2].body["dedup_key"], 32)
+	assert.Equal(t, "warning", requests[2].body["payload"].(map[string]interface{})["severity"])
+	assert.Equal(t, "plain text alert", requests[2].body["payload"].(map[string]interface{})["custom_details"].(map[string]interface{})["payload"])
+	// nothing resolves without dedup key
+	assert.NoError(t, n.Notify(monitorPayload("resolved", "info")))
+	assert.Equal(t, "trigger", requests[3].body["event_action"])
+}
+
+func TestPagerDutyNotifier_Configure(t *testing.T) {
+	n := new(PagerDutyNotifier)
+	assert.Error(t, n.Configure(&iface.Settings{}))
+	assert.Error(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG", IncidentSeverity: "apocalyptic"}))
+	assert.Error(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG", IncidentResolve: "status"}))
+	assert.Error(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG", IncidentDedupKey: []string{"cookie:id"}}))
+	assert.Error(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG", IncidentResolve: "json:state=done"}))
+	assert.NoError(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG", IncidentResolve: "json:state=done", IncidentDedupKey: []string{"json:id"}}))
+	assert.NoError(t, n.Configure(&iface.Settings{PagerDutyRoutingKey: "R0UT1NG"}))
+	assert.Equal(t, defaultPagerDutyURL, n.url)
+}
+
+func TestAlertmanagerNotifier_Notify(t *testing.T) {
+	var posted [][]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", r.URL.Path)
+		alerts := []map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&alerts))
+		posted = append(posted, alerts)
+	}))
+	defer server.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	n := new(AlertmanagerNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "monitor", URI: "/alerts", AlertmanagerURLs: []string{server.URL + "/", down.URL},
+		AlertmanagerLabels: []string{"team=storage"}, IncidentDedupKey: []string{"json:id"}, IncidentSeverityField: "json:severity"}))
+	assert.NoError(t, n.Notify(monitorPayload("firing", "critical")))
+	assert.NoError(t, n.Notify(monitorPayload("resolved", "info")))
+	assert.Len(t, posted, 2)
+	firing := posted[0][0]
+	labels := map[string]interface{}{"alertname": "monitor", "route": "/alerts", "team": "storage", "dedup_key": "/alerts/disk-42"}
+	assert.Equal(t, labels, firing["labels"])
+	assert.Equal(t, "critical", firing["annotations"].(map[string]interface{})["severity"])
+	assert.Equal(t, "2020-01-02T03:04:05Z", firing["startsAt"])
+	assert.Equal(t, "2020-01-03T03:04:05Z", firing["endsAt"])
+	assert.Equal(t, "Alert from monitor on /alerts", firing["annotations"].(map[string]interface{})["summary"])
+	// resolved with the labels it fired with
+	resolved := posted[1][0]
+	assert.Equal(t, labels, resolved["labels"])
+	ends, err := time.Parse(time.RFC3339, resolved["endsAt"].(string))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), ends, time.Minute)
+	// fails once no instance accepts the alert
+	assert.NoError(t, n.Configure(&iface.Settings{AlertmanagerURLs: []string{down.URL}}))
+	assert.Error(t, n.Notify(monitorPayload("firing", "critical")))
+	assert.Error(t, n.Configure(&iface.Settings{}))
+	assert.Error(t, n.Configure(&iface.Settings{AlertmanagerURLs: []string{down.URL}, AlertmanagerLabels: []string{"team"}}))
+}
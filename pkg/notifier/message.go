@@ -0,0 +1,170 @@
+package notifier
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/email"
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/parser"
+)
+
+// defaultTextTemplate is the plain text body used unless a template file defines one.
+const defaultTextTemplate = `{{.Body}}
+
+Sincerely,
+
+{{.From}}
+`
+
+// messageData is what message templates have access to.
+type messageData struct {
+	*iface.PayloadRecord
+	Name  string
+	Route string
+	From  string
+	To    string
+	// Body is the whole payload record as text
+	Body string
+	// Fields are the parsed form fields, see parser.ParseForm
+	Fields url.Values
+	Files  []parser.File
+}
+
+// newMessageData prepares template data of the payload, fields are empty unless the payload is a form.
+func newMessageData(name, route string, payload *iface.PayloadRecord) *messageData {
+	data := &messageData{PayloadRecord: payload, Name: name, Route: route, Body: payload.String()}
+	if form, err := parser.ParseForm(payload); err == nil {
+		data.Fields, data.Files = form.Fields, form.Files
+	} else {
+		data.Fields = url.Values{}
+	}
+	return data
+}
+
+// Field returns the first value of the named form field, handy in templates as `{{.Field "email"}}`.
+func (d *messageData) Field(name string) string {
+	return d.Fields.Get(name)
+}
+
+// messageTemplates render subject and bodies of notification messages.
+type messageTemplates struct {
+	name          string
+	route         string
+	subject       *template.Template
+	text          *template.Template
+	html          *htmltemplate.Template
+	attachPayload bool
+}
+
+// newMessageTemplates parses the subject and the template file. The file may define `subject`, `text` and `html` templates, the text body defaults to the payload followed by a signature.
+func newMessageTemplates(settings *iface.Settings, subject string) (t *messageTemplates, err error) {
+	t = &messageTemplates{name: settings.Name, route: settings.URI, attachPayload: settings.SMTPAttachPayload}
+	if t.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, errors.Wrap(err, "error parsing smtp subject template")
+	}
+	if t.text, err = template.New("text").Parse(defaultTextTemplate); err != nil {
+		return nil, errors.Wrap(err, "error parsing default text template")
+	}
+	if len(settings.SMTPTemplateFile) == 0 {
+		return t, nil
+	}
+	content, err := ioutil.ReadFile(settings.SMTPTemplateFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading smtp template file %s", settings.SMTPTemplateFile)
+	}
+	// text and html templates are parsed separately so html gets escaped properly
+	file, err := template.New("file").Parse(string(content))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing smtp template file %s", settings.SMTPTemplateFile)
+	}
+	if defined := file.Lookup("subject"); defined != nil {
+		t.subject = defined
+	}
+	if defined := file.Lookup("text"); defined != nil {
+		t.text = defined
+	} else {
+		t.text = nil
+	}
+	html, err := htmltemplate.New("file").Parse(string(content))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing smtp template file %s", settings.SMTPTemplateFile)
+	}
+	t.html = html.Lookup("html")
+	if t.text == nil && t.html == nil {
+		return nil, errors.Errorf("smtp template file %s defines neither text nor html template", settings.SMTPTemplateFile)
+	}
+	return t, nil
+}
+
+// message renders the notification of the payload as a MIME message, it returns addresses to deliver it to as well.
+func (s *SMTPNotifier) message(payload *iface.PayloadRecord) ([]byte, []string, error) {
+	data := newMessageData(s.templates.name, s.templates.route, payload)
+	data.From = s.From
+	recipients := s.recipients(payload, data.Fields)
+	envelope := recipients.envelope()
+	if len(envelope) == 0 {
+		return nil, nil, errors.New("no recipients of the notification")
+	}
+	data.To = strings.Join(formatAddresses(recipients.to), ", ")
+	subject, err := execute(s.templates.subject, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	message := &email.Message{
+		From:    s.From,
+		To:      formatAddresses(recipients.to),
+		Cc:      formatAddresses(recipients.cc),
+		Subject: strings.TrimSpace(subject),
+		Date:    time.Now(),
+	}
+	if recipients.replyTo != nil {
+		message.ReplyTo = formatAddresses([]*mail.Address{recipients.replyTo})[0]
+	}
+	if s.templates.text != nil {
+		if message.Text, err = execute(s.templates.text, data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if s.templates.html != nil {
+		var html bytes.Buffer
+		if err = s.templates.html.Execute(&html, data); err != nil {
+			return nil, nil, errors.Wrap(err, "error executing html template")
+		}
+		message.HTML = html.String()
+	}
+	if s.templates.attachPayload {
+		message.Attachments = append(message.Attachments, payloadAttachment(payload))
+	}
+	content, err := message.Bytes()
+	return content, envelope, err
+}
+
+func execute(t *template.Template, data interface{}) (string, error) {
+	var buffer bytes.Buffer
+	err := t.Execute(&buffer, data)
+	return buffer.String(), errors.Wrapf(err, "error executing %s template", t.Name())
+}
+
+// payloadAttachment attaches the raw payload with its content type.
+func payloadAttachment(payload *iface.PayloadRecord) email.Attachment {
+	attachment := email.Attachment{Filename: "payload.bin", ContentType: "application/octet-stream", Data: []byte(payload.Payload)}
+	if values := payload.Meta["Content-Type"]; len(values) > 0 {
+		attachment.ContentType = values[0]
+		if mediaType, _, err := mime.ParseMediaType(values[0]); err == nil {
+			if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+				attachment.Filename = "payload" + extensions[0]
+			}
+		}
+	}
+	return attachment
+}
@@ -0,0 +1,91 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTPNotifier_Message(t *testing.T) {
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "contact", SMTPFrom: "glutton@example.com", SMTPTo: "admin@example.com"}))
+	message, _, err := n.message(&iface.PayloadRecord{Payload: "a < b & c", Timestamp: time.Now()})
+	assert.NoError(t, err)
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+	assert.NoError(t, err)
+	assert.Equal(t, "Notification from contact", parsed.Header.Get("Subject"))
+	assert.Equal(t, "1.0", parsed.Header.Get("MIME-Version"))
+	assert.NotEmpty(t, parsed.Header.Get("Message-ID"))
+	_, err = parsed.Header.Date()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(parsed.Header.Get("Content-Type"), "text/plain"))
+	body, _ := ioutil.ReadAll(parsed.Body)
+	// text is not escaped
+	assert.Contains(t, string(body), "a < b & c")
+	assert.Contains(t, string(body), "Sincerely,")
+}
+
+func TestSMTPNotifier_MessageTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mail.tmpl")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{{define "text"}}{{.Field "name"}} wrote {{.Field "message"}} on {{.Route}}{{end}}`+
+		`{{define "html"}}<p>{{.Field "message"}}</p>{{end}}`), 0644))
+	n := new(SMTPNotifier)
+	settings := &iface.Settings{Name: "contact", URI: "/contact", SMTPFrom: "glutton@example.com", SMTPTo: "admin@example.com",
+		SMTPSubject: `Message from {{.Field "name"}}`, SMTPTemplateFile: file, SMTPAttachPayload: true}
+	assert.NoError(t, n.Configure(settings))
+	payload := &iface.PayloadRecord{
+		Payload:   "name=Jan&message=%3Cb%3Ehi%3C%2Fb%3E",
+		Meta:      map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}},
+		Timestamp: time.Now(),
+	}
+	message, _, err := n.message(payload)
+	assert.NoError(t, err)
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+	assert.NoError(t, err)
+	assert.Equal(t, "Message from Jan", parsed.Header.Get("Subject"))
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", mediaType)
+	mixed := multipart.NewReader(parsed.Body, params["boundary"])
+	part, err := mixed.NextPart()
+	assert.NoError(t, err)
+	mediaType, params, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	assert.Equal(t, "multipart/alternative", mediaType)
+	alternative := multipart.NewReader(part, params["boundary"])
+	text, err := alternative.NextPart()
+	assert.NoError(t, err)
+	content, _ := ioutil.ReadAll(text)
+	assert.Equal(t, "Jan wrote <b>hi</b> on /contact", string(content))
+	html, err := alternative.NextPart()
+	assert.NoError(t, err)
+	content, _ = ioutil.ReadAll(html)
+	assert.Equal(t, "<p>&lt;b&gt;hi&lt;/b&gt;</p>", string(content))
+	attachment, err := mixed.NextPart()
+	assert.NoError(t, err)
+	assert.Equal(t, "application/x-www-form-urlencoded", attachment.Header.Get("Content-Type"))
+	assert.Equal(t, "payload", strings.TrimSuffix(attachment.FileName(), filepath.Ext(attachment.FileName())))
+}
+
+func TestSMTPNotifier_MessageTemplateErrors(t *testing.T) {
+	n := new(SMTPNotifier)
+	assert.Error(t, n.Configure(&iface.Settings{SMTPSubject: "{{.Name"}))
+	assert.Error(t, n.Configure(&iface.Settings{SMTPTemplateFile: "/nonexistent"}))
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mail.tmpl")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{{define "other"}}{{end}}`), 0644))
+	assert.Error(t, n.Configure(&iface.Settings{SMTPTemplateFile: file}))
+}
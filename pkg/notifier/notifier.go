@@ -1,10 +1,15 @@
 package notifier
 
 import (
-	"bytes"
-	"html/template"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"os"
+	"time"
 
 	"github.com/defectus/glutton/pkg/iface"
 	"github.com/pkg/errors"
@@ -13,15 +18,41 @@ import (
 // NilNotifier implements the glutton.PayloadNotifier interface but does nothing.
 type NilNotifier struct{}
 
+const (
+	// smtpImplicitTLS connects using TLS right away (SMTPS, port 465).
+	smtpImplicitTLS = "tls"
+	// smtpStartTLS requires the connection to be upgraded by STARTTLS.
+	smtpStartTLS = "starttls"
+	// smtpOpportunistic upgrades the connection if the server offers STARTTLS.
+	smtpOpportunistic = "opportunistic"
+	// smtpNone never encrypts the connection.
+	smtpNone = "none"
+	// smtpPlainAuth authenticates using PLAIN.
+	smtpPlainAuth = "plain"
+	// smtpNoAuth doesn't authenticate, for local relays.
+	smtpNoAuth = "none"
+)
+
 // SMTPNotifier implements the glutton.PayloadNotifier interface and delivers notifications over SMTP.
 type SMTPNotifier struct {
-	Server   string
-	Port     string
-	UseTLS   bool
-	From     string
-	Password string
-	To       string
-	Subject  string
+	Server    string
+	Port      string
+	UseTLS    bool
+	Security  string
+	Auth      string
+	From      string
+	Username  string
+	Password  string
+	To        string
+	Subject   string
+	Timeout   time.Duration
+	tlsConfig *tls.Config
+	localName string
+	templates *messageTemplates
+	// to, cc and bcc are the default recipients
+	to, cc, bcc  []*mail.Address
+	replyToField string
+	rules        []*recipientRule
 }
 
 // Notify does nothing.
@@ -34,55 +65,184 @@ func (n *NilNotifier) Configure(*iface.Settings) error {
 	return nil
 }
 
-// PayloadToSMTPMessage takes payload and format it to SMTP format.
+// PayloadToSMTPMessage takes payload and format it to SMTP format. Errors are logged, Notify fails on them instead.
 func (s *SMTPNotifier) PayloadToSMTPMessage(payload *iface.PayloadRecord) []byte {
-	smtpTemplateData := &struct {
-		From    string
-		To      string
-		Subject string
-		Body    string
-	}{s.From, s.To, s.Subject, payload.String()}
-	const emailTemplate = `From: {{.From}}
-To: {{.To}}
-Subject: {{.Subject}}
-
-{{.Body}}
-
-Sincerely,
-
-{{.From}}
-`
-	var err error
-	var doc bytes.Buffer
-
-	t := template.New("emailTemplate")
-	t, err = t.Parse(emailTemplate)
-	if err != nil {
-		log.Printf("error trying to parse mail template %+v", err)
-	}
-	err = t.Execute(&doc, smtpTemplateData)
+	message, _, err := s.message(payload)
 	if err != nil {
-		log.Printf("error trying to execute mail template %+v", err)
+		log.Printf("error trying to build mail message %+v", err)
 	}
-	return doc.Bytes()
+	return message
 }
 
 // Notify sends notification over SMTP.
 func (s *SMTPNotifier) Notify(payload *iface.PayloadRecord) error {
-	err := smtp.SendMail(s.Server+":"+s.Port,
-		smtp.PlainAuth("", s.From, s.Password, s.Server),
-		s.From, []string{s.To}, s.PayloadToSMTPMessage(payload))
-	return errors.Wrapf(err, "error sending notification %+v", payload)
+	message, to, err := s.message(payload)
+	if err != nil {
+		return err
+	}
+	err = s.send(s.From, to, message)
+	return errors.Wrap(err, "error sending notification")
+}
+
+// send delivers the message. Unlike smtp.SendMail it honours the configured security, TLS is never given up once required.
+func (s *SMTPNotifier) send(from string, to []string, message []byte) error {
+	address := net.JoinHostPort(s.Server, s.Port)
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.Security == smtpImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to %s", address)
+	}
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+	client, err := smtp.NewClient(conn, s.Server)
+	if err != nil {
+		conn.Close()
+		return errors.Wrapf(err, "error greeting %s", address)
+	}
+	defer client.Close()
+	if err = client.Hello(s.localName); err != nil {
+		return errors.Wrap(err, "error saying hello")
+	}
+	if s.Security == smtpStartTLS || s.Security == smtpOpportunistic {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(s.tlsConfig); err != nil {
+				return errors.Wrap(err, "error starting tls")
+			}
+		} else if s.Security == smtpStartTLS {
+			return errors.Errorf("%s does not offer STARTTLS but tls is required, not sending", address)
+		}
+	}
+	if s.Auth != smtpNoAuth {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.Errorf("%s does not offer authentication, set smtp_auth to none for relays without it", address)
+		}
+		username := s.Username
+		if len(username) == 0 {
+			username = s.From
+		}
+		// plain auth refuses to send credentials over an unencrypted connection (except to localhost)
+		if err = client.Auth(smtp.PlainAuth("", username, s.Password, s.Server)); err != nil {
+			return errors.Wrap(err, "error authenticating")
+		}
+	}
+	if err = client.Mail(from); err != nil {
+		return errors.Wrapf(err, "error sending from %s", from)
+	}
+	for _, recipient := range to {
+		if err = client.Rcpt(recipient); err != nil {
+			return errors.Wrapf(err, "error sending to %s", recipient)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "error sending message")
+	}
+	if _, err = w.Write(message); err != nil {
+		return errors.Wrap(err, "error sending message")
+	}
+	if err = w.Close(); err != nil {
+		return errors.Wrap(err, "error sending message")
+	}
+	return client.Quit()
 }
 
 // Configure configures this notifier according to settings.
-func (s *SMTPNotifier) Configure(settings *iface.Settings) error {
+// Namely the following params are used:
+// * SMTPServer, SMTPPort - server to send notifications through
+// * SMTPSecurity - `tls` (implicit, usually port 465), `starttls` (required), `opportunistic` (STARTTLS if offered) or `none`; derived from SMTPUseTLS and the port if empty
+// * SMTPCAFile - PEM bundle of certificate authorities trusted in addition to the system ones
+// * SMTPAuth - `plain` (default) or `none` for relays without authentication
+// * SMTPUsername, SMTPPassword - credentials, the username defaults to SMTPFrom
+// * SMTPTimeout - timeout of the whole delivery
+// * SMTPTo, SMTPCc, SMTPBcc - comma separated default recipients
+// * SMTPReplyToField - form field holding the Reply-To address
+// * SMTPRules - rules choosing recipients by payload content
+// * SMTPSubject, SMTPTemplateFile, SMTPAttachPayload - templates of the message and whether to attach the payload
+func (s *SMTPNotifier) Configure(settings *iface.Settings) (err error) {
 	s.Server = settings.SMTPServer
 	s.Port = settings.SMTPPort
 	s.UseTLS = settings.SMTPUseTLS
 	s.From = settings.SMTPFrom
+	s.Username = settings.SMTPUsername
 	s.Password = settings.SMTPPassword
 	s.To = settings.SMTPTo
-	s.Subject = "Notification from " + settings.Name
+	if s.to, err = parseAddresses(settings.SMTPTo); err != nil {
+		return err
+	}
+	if s.cc, err = parseAddresses(settings.SMTPCc); err != nil {
+		return err
+	}
+	if s.bcc, err = parseAddresses(settings.SMTPBcc); err != nil {
+		return err
+	}
+	s.replyToField = settings.SMTPReplyToField
+	s.rules = nil
+	for _, rule := range settings.SMTPRules {
+		parsed, err := parseRecipientRule(rule)
+		if err != nil {
+			return err
+		}
+		s.rules = append(s.rules, parsed)
+	}
+	s.Subject = settings.SMTPSubject
+	if len(s.Subject) == 0 {
+		s.Subject = "Notification from {{.Name}}"
+	}
+	if s.templates, err = newMessageTemplates(settings, s.Subject); err != nil {
+		return err
+	}
+	s.Security = settings.SMTPSecurity
+	if len(s.Security) == 0 {
+		switch {
+		case s.UseTLS && s.Port == "465":
+			s.Security = smtpImplicitTLS
+		case s.UseTLS:
+			s.Security = smtpStartTLS
+		default:
+			s.Security = smtpOpportunistic
+		}
+	}
+	switch s.Security {
+	case smtpImplicitTLS, smtpStartTLS, smtpOpportunistic, smtpNone:
+	default:
+		return errors.Errorf("unknown smtp security %s", s.Security)
+	}
+	s.Auth = settings.SMTPAuth
+	if len(s.Auth) == 0 {
+		s.Auth = smtpPlainAuth
+	}
+	if s.Auth != smtpPlainAuth && s.Auth != smtpNoAuth {
+		return errors.Errorf("unknown smtp auth %s", s.Auth)
+	}
+	if s.Timeout, err = iface.ParseDuration(settings.SMTPTimeout, 30*time.Second); err != nil {
+		return err
+	}
+	s.tlsConfig = &tls.Config{ServerName: s.Server, MinVersion: tls.VersionTLS12}
+	if len(settings.SMTPCAFile) > 0 {
+		pem, err := ioutil.ReadFile(settings.SMTPCAFile)
+		if err != nil {
+			return errors.Wrapf(err, "error reading smtp ca file %s", settings.SMTPCAFile)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificates found in smtp ca file %s", settings.SMTPCAFile)
+		}
+		s.tlsConfig.RootCAs = pool
+	}
+	if s.localName, err = os.Hostname(); err != nil || len(s.localName) == 0 {
+		s.localName = "localhost"
+	}
 	return nil
 }
@@ -0,0 +1,237 @@
+package notifier
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	defaultNtfyURL   = "https://ntfy.sh"
+	defaultPushTitle = "Notification from {{.Name}}"
+	// defaultPushPriority is the default priority of ntfy, priorities range from 1 (min) to 5 (max).
+	defaultPushPriority = 3
+	// ntfyMaxLength keeps messages under the size ntfy turns into attachments.
+	ntfyMaxLength = 4000
+	// gotifyMaxLength keeps messages readable in the Gotify clients.
+	gotifyMaxLength = 10000
+	// matrixMaxLength keeps events well under the 64 KB limit of Matrix.
+	matrixMaxLength = 20000
+)
+
+// gotifyPriorities maps priorities 1-5 to the 0-10 scale of Gotify.
+var gotifyPriorities = [...]int{0, 1, 3, 5, 8, 10}
+
+// pushPriority derives priority of a notification from the payload.
+type pushPriority struct {
+	selector *selector
+	mapping  map[string]int
+	fallback int
+}
+
+// newPushPriority configures priorities. Values found by PushPriorityField are mapped by PushPriorityMap (`value=priority` items), numbers 1-5 are used as they are, PushPriority applies otherwise.
+func newPushPriority(settings *iface.Settings) (p *pushPriority, err error) {
+	p = &pushPriority{mapping: map[string]int{}, fallback: settings.PushPriority}
+	if p.fallback == 0 {
+		p.fallback = defaultPushPriority
+	}
+	if p.fallback < 1 || p.fallback > 5 {
+		return nil, errors.Errorf("push priority %d out of range 1-5", p.fallback)
+	}
+	if len(settings.PushPriorityField) > 0 {
+		if p.selector, err = parseSelector(settings.PushPriorityField); err != nil {
+			return nil, errors.Wrap(err, "error parsing push priority field")
+		}
+	}
+	for _, item := range settings.PushPriorityMap {
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, errors.Errorf("push priority map item %s isn't value=priority", item)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(item[i+1:]))
+		if err != nil || priority < 1 || priority > 5 {
+			return nil, errors.Errorf("push priority map item %s has priority out of range 1-5", item)
+		}
+		p.mapping[strings.ToLower(strings.TrimSpace(item[:i]))] = priority
+	}
+	return p, nil
+}
+
+// of returns the priority of the payload.
+func (p *pushPriority) of(payload *iface.PayloadRecord) int {
+	if p.selector == nil {
+		return p.fallback
+	}
+	for _, value := range p.selector.values(payload, &ruleData{payload: payload}) {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if priority, found := p.mapping[value]; found {
+			return priority
+		}
+		if priority, err := strconv.Atoi(value); err == nil && priority >= 1 && priority <= 5 {
+			return priority
+		}
+	}
+	return p.fallback
+}
+
+// pushNotifier is a chatNotifier sending a title and a priority along with the message.
+type pushNotifier struct {
+	chatNotifier
+	title    *template.Template
+	priority *pushPriority
+}
+
+func (p *pushNotifier) configurePush(settings *iface.Settings, service, url string, maxLength int) (err error) {
+	if err = p.configure(settings, service, url, maxLength); err != nil {
+		return err
+	}
+	title := settings.PushTitle
+	if len(title) == 0 {
+		title = defaultPushTitle
+	}
+	if p.title, err = template.New(service + " title").Parse(title); err != nil {
+		return errors.Wrapf(err, "error parsing %s title template", service)
+	}
+	p.priority, err = newPushPriority(settings)
+	return err
+}
+
+// message renders title and text of the notification and finds out its priority.
+func (p *pushNotifier) message(payload *iface.PayloadRecord) (title, text string, priority int, err error) {
+	if text, err = p.text(payload); err != nil {
+		return "", "", 0, err
+	}
+	if title, err = execute(p.title, newMessageData(p.name, p.route, payload)); err != nil {
+		return "", "", 0, err
+	}
+	return strings.TrimSpace(title), text, p.priority.of(payload), nil
+}
+
+// NtfyNotifier publishes notifications to a ntfy topic.
+type NtfyNotifier struct {
+	pushNotifier
+	topic string
+	token string
+	tags  []string
+}
+
+// Configure configures the NtfyNotifier.
+// Namely the following params are used:
+// * NtfyURL, NtfyTopic - the server (ntfy.sh by default) and topic to publish to
+// * NtfyToken - access token, if the topic is protected
+// * NtfyTags - tags (or emoji shortcodes) of the notifications
+// * PushTitle, PushPriority, PushPriorityField, PushPriorityMap - title template and priority of the notifications
+// * ChatTemplate, ChatMaxLength, ChatTimeout - template of the message, its maximum length and timeout of the request
+func (n *NtfyNotifier) Configure(settings *iface.Settings) error {
+	if len(settings.NtfyTopic) == 0 {
+		return errors.New("ntfy topic not set")
+	}
+	server := settings.NtfyURL
+	if len(server) == 0 {
+		server = defaultNtfyURL
+	}
+	n.topic = settings.NtfyTopic
+	n.token = settings.NtfyToken
+	n.tags = settings.NtfyTags
+	return n.configurePush(settings, "ntfy", strings.TrimRight(server, "/")+"/", ntfyMaxLength)
+}
+
+// Notify publishes the summary of the payload.
+func (n *NtfyNotifier) Notify(payload *iface.PayloadRecord) error {
+	title, text, priority, err := n.message(payload)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if len(n.token) > 0 {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	message := map[string]interface{}{"topic": n.topic, "title": title, "message": text, "priority": priority}
+	if len(n.tags) > 0 {
+		message["tags"] = n.tags
+	}
+	return n.send(http.MethodPost, n.url, header, message)
+}
+
+// GotifyNotifier sends notifications as messages of a Gotify application.
+type GotifyNotifier struct {
+	pushNotifier
+	token string
+}
+
+// Configure configures the GotifyNotifier.
+// Namely the following params are used:
+// * GotifyURL, GotifyToken - the server and token of the application
+// * PushTitle, PushPriority, PushPriorityField, PushPriorityMap - title template and priority of the notifications
+// * ChatTemplate, ChatMaxLength, ChatTimeout - template of the message, its maximum length and timeout of the request
+func (g *GotifyNotifier) Configure(settings *iface.Settings) error {
+	if len(settings.GotifyToken) == 0 {
+		return errors.New("gotify token not set")
+	}
+	g.token = settings.GotifyToken
+	server := settings.GotifyURL
+	if len(server) > 0 {
+		server = strings.TrimRight(server, "/") + "/message"
+	}
+	return g.configurePush(settings, "gotify", server, gotifyMaxLength)
+}
+
+// Notify sends the summary of the payload, priorities are scaled to 0-10.
+func (g *GotifyNotifier) Notify(payload *iface.PayloadRecord) error {
+	title, text, priority, err := g.message(payload)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Gotify-Key", g.token)
+	return g.send(http.MethodPost, g.url, header, map[string]interface{}{"title": title, "message": text, "priority": gotifyPriorities[priority]})
+}
+
+// MatrixNotifier sends notifications to a Matrix room using the client-server API.
+type MatrixNotifier struct {
+	pushNotifier
+	token string
+}
+
+// Configure configures the MatrixNotifier.
+// Namely the following params are used:
+// * MatrixHomeserver, MatrixRoomID, MatrixToken - the homeserver, room to send to (the user must have joined it) and access token of the user
+// * PushTitle, PushPriority, PushPriorityField, PushPriorityMap - title template and priority of the notifications
+// * ChatTemplate, ChatMaxLength, ChatTimeout - template of the message, its maximum length and timeout of the request
+func (m *MatrixNotifier) Configure(settings *iface.Settings) error {
+	if len(settings.MatrixRoomID) == 0 || len(settings.MatrixToken) == 0 {
+		return errors.New("matrix room id or token not set")
+	}
+	m.token = settings.MatrixToken
+	server := settings.MatrixHomeserver
+	if len(server) > 0 {
+		server = strings.TrimRight(server, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(settings.MatrixRoomID) + "/send/m.room.message/"
+	}
+	return m.configurePush(settings, "matrix", server, matrixMaxLength)
+}
+
+// Notify sends the title and the summary of the payload as a single message. Low priority (1, 2) notifications are sent as notices, which clients don't alert of by default.
+func (m *MatrixNotifier) Notify(payload *iface.PayloadRecord) error {
+	title, text, priority, err := m.message(payload)
+	if err != nil {
+		return err
+	}
+	if len(title) > 0 {
+		text = title + "\n\n" + text
+	}
+	msgtype := "m.text"
+	if priority <= 2 {
+		msgtype = "m.notice"
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+m.token)
+	// every notification is a new transaction
+	return m.send(http.MethodPut, m.url+uuid.NewString(), header, map[string]string{"msgtype": msgtype, "body": text})
+}
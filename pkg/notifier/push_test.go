@@ -0,0 +1,105 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// pushRequest is a request received by the push server.
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+func pushServer(t *testing.T, requests *[]pushRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := pushRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header, body: map[string]interface{}{}}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request.body))
+		*requests = append(*requests, request)
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+var alertPayload = &iface.PayloadRecord{
+	Payload: `{"severity": "critical", "summary": "disk full"}`,
+	Meta:    map[string][]string{"Content-Type": {"application/json"}},
+}
+
+func TestPushPriority(t *testing.T) {
+	p, err := newPushPriority(&iface.Settings{PushPriorityField: "json:severity", PushPriorityMap: []string{"critical=5", " Warning = 4"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, p.of(alertPayload))
+	assert.Equal(t, 4, p.of(&iface.PayloadRecord{Payload: `{"severity": "WARNING"}`}))
+	assert.Equal(t, 2, p.of(&iface.PayloadRecord{Payload: `{"severity": 2}`}))
+	assert.Equal(t, 3, p.of(&iface.PayloadRecord{Payload: `{"severity": "info"}`}))
+	assert.Equal(t, 3, p.of(&iface.PayloadRecord{Payload: "not json"}))
+	p, err = newPushPriority(&iface.Settings{PushPriority: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.of(alertPayload))
+	for _, settings := range []*iface.Settings{
+		{PushPriority: 6},
+		{PushPriorityField: "cookie:x"},
+		{PushPriorityMap: []string{"critical"}},
+		{PushPriorityMap: []string{"critical=9"}},
+	} {
+		_, err = newPushPriority(settings)
+		assert.Error(t, err)
+	}
+}
+
+func TestNtfyNotifier_Notify(t *testing.T) {
+	var requests []pushRequest
+	server := pushServer(t, &requests)
+	n := new(NtfyNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "alerts", NtfyURL: server.URL + "/", NtfyTopic: "oncall", NtfyToken: "tk_secret",
+		NtfyTags: []string{"warning"}, PushPriorityField: "json:severity", PushPriorityMap: []string{"critical=5"}, ChatTemplate: `{{.Field "summary"}}`}))
+	assert.NoError(t, n.Notify(alertPayload))
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "/", requests[0].path)
+	assert.Equal(t, "Bearer tk_secret", requests[0].header.Get("Authorization"))
+	assert.Equal(t, map[string]interface{}{"topic": "oncall", "title": "Notification from alerts", "message": "disk full", "priority": float64(5), "tags": []interface{}{"warning"}}, requests[0].body)
+	assert.Error(t, n.Configure(&iface.Settings{}))
+}
+
+func TestGotifyNotifier_Notify(t *testing.T) {
+	var requests []pushRequest
+	server := pushServer(t, &requests)
+	n := new(GotifyNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{GotifyURL: server.URL, GotifyToken: "app-token", PushTitle: "{{.Route}}", URI: "/alerts", PushPriority: 4}))
+	assert.NoError(t, n.Notify(alertPayload))
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "/message", requests[0].path)
+	assert.Equal(t, "app-token", requests[0].header.Get("X-Gotify-Key"))
+	assert.Equal(t, "/alerts", requests[0].body["title"])
+	assert.Equal(t, float64(8), requests[0].body["priority"])
+	assert.Error(t, n.Configure(&iface.Settings{GotifyURL: server.URL}))
+	assert.Error(t, n.Configure(&iface.Settings{GotifyToken: "app-token"}))
+}
+
+func TestMatrixNotifier_Notify(t *testing.T) {
+	var requests []pushRequest
+	server := pushServer(t, &requests)
+	n := new(MatrixNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{Name: "alerts", MatrixHomeserver: server.URL, MatrixRoomID: "!room:example.org", MatrixToken: "syt_token",
+		ChatTemplate: "{{.Payload}}", PushPriorityField: "header:X-Priority"}))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "first", Meta: map[string][]string{"X-Priority": {"1"}}}))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "second"}))
+	assert.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[0].method)
+	assert.True(t, strings.HasPrefix(requests[0].path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/"), requests[0].path)
+	assert.NotEqual(t, requests[0].path, requests[1].path)
+	assert.Equal(t, "Bearer syt_token", requests[0].header.Get("Authorization"))
+	assert.Equal(t, map[string]interface{}{"msgtype": "m.notice", "body": "Notification from alerts\n\nfirst"}, requests[0].body)
+	assert.Equal(t, "m.text", requests[1].body["msgtype"])
+	assert.Error(t, n.Configure(&iface.Settings{MatrixHomeserver: server.URL}))
+}
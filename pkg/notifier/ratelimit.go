@@ -0,0 +1,82 @@
+package notifier
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+// errRateLimited refuses a digest over the limit, so the digest keeps its payload for the next one.
+var errRateLimited = errors.New("notification rate limit reached")
+
+// RateLimitNotifier passes at most limit notifications within any interval to the wrapped notifier, the rest is dropped. Notifiers sharing a limit draw on the same budget.
+type RateLimitNotifier struct {
+	notifier iface.PayloadNotifier
+	budget   *rateBudget
+}
+
+// rateBudget holds the notifications passed by the notifiers sharing a limit.
+type rateBudget struct {
+	route    string
+	limit    int
+	interval time.Duration
+	mutex    sync.Mutex
+	// sent holds times of the notifications passed within the last interval, oldest first
+	sent    []time.Time
+	dropped int
+}
+
+// NewRateLimitNotifier wraps the notifier into a RateLimitNotifier.
+func NewRateLimitNotifier(notifier iface.PayloadNotifier, route string, limit int, interval time.Duration) *RateLimitNotifier {
+	return &RateLimitNotifier{notifier: notifier, budget: &rateBudget{route: route, limit: limit, interval: interval}}
+}
+
+// Share wraps another notifier into a RateLimitNotifier drawing on the same limit.
+func (r *RateLimitNotifier) Share(notifier iface.PayloadNotifier) *RateLimitNotifier {
+	return &RateLimitNotifier{notifier: notifier, budget: r.budget}
+}
+
+// Configure does nothing, the wrapped notifier is expected to be configured already.
+func (r *RateLimitNotifier) Configure(*iface.Settings) error {
+	return nil
+}
+
+// Notify passes the notification on unless the limit is reached. Dropped notifications are not an error, they are counted and logged once notifications pass again. Digests over the limit are refused instead, their payload waits for the next digest.
+func (r *RateLimitNotifier) Notify(payload *iface.PayloadRecord) error {
+	if !r.allow(time.Now()) {
+		if payload != nil && len(payload.Meta[DigestHeader]) > 0 {
+			return errRateLimited
+		}
+		return nil
+	}
+	return r.notifier.Notify(payload)
+}
+
+// allow tells whether a notification may be sent now, and if so records it.
+func (r *RateLimitNotifier) allow(now time.Time) bool {
+	b := r.budget
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := 0
+	for n < len(b.sent) && now.Sub(b.sent[n]) >= b.interval {
+		n++
+	}
+	b.sent = b.sent[n:]
+	if len(b.sent) >= b.limit {
+		if b.dropped == 0 {
+			log.Printf("%s: notification rate limit of %d per %s reached, dropping notifications", b.route, b.limit, b.interval)
+		}
+		b.dropped++
+		return false
+	}
+	if b.dropped > 0 {
+		log.Printf("%s: %d notifications dropped by rate limit", b.route, b.dropped)
+		b.dropped = 0
+	}
+	b.sent = append(b.sent, now)
+	return true
+}
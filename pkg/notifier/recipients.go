@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/parser"
+)
+
+// recipientRule sends notifications of payload meeting the conditions of its rule to its recipients.
+type recipientRule struct {
+	*rule
+	to []*mail.Address
+}
+
+// parseRecipientRule parses a notify rule (see parseRule) naming recipients rather than notifiers, `field:department=^sales$ -> sales@example.com`.
+func parseRecipientRule(text string) (*recipientRule, error) {
+	parsed, err := parseRule("smtp", text)
+	if err != nil {
+		return nil, err
+	}
+	r := &recipientRule{rule: parsed}
+	for _, target := range parsed.targets {
+		address, err := mail.ParseAddress(target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing recipient of smtp rule %s", text)
+		}
+		r.to = append(r.to, address)
+	}
+	return r, nil
+}
+
+// parseAddresses parses a comma separated list of addresses, empty list yields none.
+func parseAddresses(list string) ([]*mail.Address, error) {
+	if len(strings.TrimSpace(list)) == 0 {
+		return nil, nil
+	}
+	addresses, err := mail.ParseAddressList(list)
+	return addresses, errors.Wrapf(err, "error parsing addresses %s", list)
+}
+
+// recipients holds addresses of a single notification.
+type recipients struct {
+	to, cc, bcc []*mail.Address
+	replyTo     *mail.Address
+}
+
+// recipients chooses recipients of the payload. Recipients of all matching rules replace the default ones, Reply-To is taken from the form field if it holds a valid address.
+func (s *SMTPNotifier) recipients(payload *iface.PayloadRecord, fields url.Values) *recipients {
+	r := &recipients{to: s.to, cc: s.cc, bcc: s.bcc}
+	data := &ruleData{payload: payload, parsed: &parser.Form{Fields: fields}}
+	var matched []*mail.Address
+	for _, rule := range s.rules {
+		if rule.matches(payload, data) {
+			matched = append(matched, rule.to...)
+		}
+	}
+	if len(matched) > 0 {
+		r.to = matched
+	}
+	if len(s.replyToField) > 0 {
+		if value := fields.Get(s.replyToField); len(value) > 0 {
+			if address, err := mail.ParseAddress(value); err == nil {
+				r.replyTo = address
+			}
+		}
+	}
+	return r
+}
+
+// envelope returns addresses to deliver to, each one once.
+func (r *recipients) envelope() []string {
+	seen := map[string]bool{}
+	var addresses []string
+	for _, list := range [][]*mail.Address{r.to, r.cc, r.bcc} {
+		for _, address := range list {
+			if key := strings.ToLower(address.Address); !seen[key] {
+				seen[key] = true
+				addresses = append(addresses, address.Address)
+			}
+		}
+	}
+	return addresses
+}
+
+// formatAddresses formats addresses for message headers, bare addresses are kept as they are.
+func formatAddresses(addresses []*mail.Address) []string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = address.Address
+		if len(address.Name) > 0 {
+			formatted[i] = address.String()
+		}
+	}
+	return formatted
+}
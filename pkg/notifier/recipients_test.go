@@ -0,0 +1,73 @@
+package notifier
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecipientRule(t *testing.T) {
+	rule, err := parseRecipientRule("field:department=^sales$ -> sales@example.com crm@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "field", rule.conditions[0].subject)
+	assert.Equal(t, "department", rule.conditions[0].name)
+	assert.Len(t, rule.to, 2)
+	rule, err = parseRecipientRule("header:x-department=support && json:amount>=1000 -> support@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "X-Department", rule.conditions[0].name)
+	assert.Len(t, rule.conditions, 2)
+	for _, invalid := range []string{"field:department=sales", "-> ", "department=sales -> sales@example.com", "field:department=( -> sales@example.com", "field:department=sales -> not-an-address"} {
+		_, err = parseRecipientRule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSMTPNotifier_Recipients(t *testing.T) {
+	server := newFakeSMTP(t, false, false, false)
+	settings := server.settings()
+	settings.SMTPSecurity = "none"
+	settings.SMTPAuth = "none"
+	settings.SMTPTo = "Admin <admin@example.com>, office@example.com"
+	settings.SMTPCc = "boss@example.com"
+	settings.SMTPBcc = "archive@example.com"
+	settings.SMTPReplyToField = "email"
+	settings.SMTPRules = []string{"field:department=(?i)^sales$ -> sales@example.com", "header:X-Priority=urgent -> boss@example.com"}
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(settings))
+	form := map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}}
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "department=support&email=jan%40example.org", Meta: form, Timestamp: time.Now()}))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "department=Sales&email=invalid", Meta: form, Timestamp: time.Now()}))
+	mails := server.received()
+	assert.Len(t, mails, 2)
+	assert.Equal(t, []string{"admin@example.com", "office@example.com", "boss@example.com", "archive@example.com"}, mails[0].to)
+	message, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	assert.NoError(t, err)
+	assert.Equal(t, `"Admin" <admin@example.com>, office@example.com`, message.Header.Get("To"))
+	assert.Equal(t, "boss@example.com", message.Header.Get("Cc"))
+	assert.Equal(t, "jan@example.org", message.Header.Get("Reply-To"))
+	assert.Empty(t, message.Header.Get("Bcc"))
+	// routed to sales, reply-to ignored as the address is invalid
+	assert.Equal(t, []string{"sales@example.com", "boss@example.com", "archive@example.com"}, mails[1].to)
+	message, err = mail.ReadMessage(strings.NewReader(mails[1].data))
+	assert.NoError(t, err)
+	assert.Equal(t, "sales@example.com", message.Header.Get("To"))
+	assert.Empty(t, message.Header.Get("Reply-To"))
+	// header rule
+	form["X-Priority"] = []string{"urgent"}
+	n.cc = nil
+	_, to, err := n.message(&iface.PayloadRecord{Payload: "department=support", Meta: form})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"boss@example.com", "archive@example.com"}, to)
+}
+
+func TestSMTPNotifier_NoRecipients(t *testing.T) {
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{SMTPFrom: "glutton@example.com"}))
+	assert.Error(t, n.Notify(&iface.PayloadRecord{Payload: "test payload"}))
+	assert.Error(t, n.Configure(&iface.Settings{SMTPTo: "not an address"}))
+	assert.Error(t, n.Configure(&iface.Settings{SMTPRules: []string{"department"}}))
+}
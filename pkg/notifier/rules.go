@@ -0,0 +1,312 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/parser"
+)
+
+const (
+	// ruleArrow separates conditions of a rule from its targets (notifiers or recipients), the last one counts as targets never contain it.
+	ruleArrow = "->"
+	// ruleAnd separates conditions of a rule, patterns needing it can write `&{2}`.
+	ruleAnd = "&&"
+)
+
+// selector picks values of the payload. Subjects are `header:Name`, `field:name` (a form field), `json:path` (dot separated, numbers index arrays), `body` and `size` (of the body in bytes).
+type selector struct {
+	subject string
+	name    string
+}
+
+// parseSelector parses `subject` or `subject:name`.
+func parseSelector(text string) (*selector, error) {
+	s := &selector{subject: text}
+	if i := strings.Index(text, ":"); i >= 0 {
+		s.subject, s.name = text[:i], text[i+1:]
+	}
+	switch s.subject {
+	case "header":
+		s.name = http.CanonicalHeaderKey(s.name)
+	case "field", "json", "body", "size":
+	default:
+		return nil, errors.Errorf("%s has unknown subject %s", text, s.subject)
+	}
+	if (s.subject == "header" || s.subject == "field" || s.subject == "json") && len(s.name) == 0 {
+		return nil, errors.Errorf("%s needs a name", text)
+	}
+	return s, nil
+}
+
+// values returns values of the subject in the payload.
+func (s *selector) values(payload *iface.PayloadRecord, data *ruleData) []string {
+	switch s.subject {
+	case "header":
+		return payload.Meta[s.name]
+	case "field":
+		return data.form().Fields[s.name]
+	case "json":
+		if value, found := jsonPath(data.json(), s.name); found {
+			return []string{value}
+		}
+		return nil
+	case "body":
+		return []string{payload.Payload}
+	default:
+		return []string{fmt.Sprint(len(payload.Payload))}
+	}
+}
+
+// condition tests values picked by its selector.
+type condition struct {
+	*selector
+	op      string
+	pattern *regexp.Regexp
+	number  float64
+}
+
+// parseCondition parses `selector<op>value`. Operators `=` and `!=` match the value as a regular expression, `>`, `>=`, `<` and `<=` compare numbers.
+func parseCondition(text string) (*condition, error) {
+	i := strings.IndexAny(text, "=!<>")
+	if i <= 0 {
+		return nil, errors.Errorf("condition %s has no operator", text)
+	}
+	selector, err := parseSelector(text[:i])
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing condition %s", text)
+	}
+	c := &condition{selector: selector}
+	op, value := text[i:i+1], text[i+1:]
+	if len(value) > 0 && value[0] == '=' && op != "=" {
+		op, value = op+"=", value[1:]
+	}
+	c.op = op
+	switch c.op {
+	case "=", "!=":
+		if c.pattern, err = regexp.Compile(value); err != nil {
+			return nil, errors.Wrapf(err, "error parsing pattern of condition %s", text)
+		}
+	case ">", ">=", "<", "<=":
+		if c.number, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, errors.Wrapf(err, "error parsing number of condition %s", text)
+		}
+	default:
+		return nil, errors.Errorf("condition %s has unknown operator %s", text, c.op)
+	}
+	return c, nil
+}
+
+// matches tells whether the payload meets the condition. `=` needs any of the values to match, `!=` none of them; numbers are compared with the first value.
+func (c *condition) matches(payload *iface.PayloadRecord, data *ruleData) bool {
+	values := c.values(payload, data)
+	if c.pattern != nil {
+		matched := false
+		for _, value := range values {
+			if c.pattern.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		return matched == (c.op == "=")
+	}
+	if len(values) == 0 {
+		return false
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+	if err != nil {
+		return false
+	}
+	switch c.op {
+	case ">":
+		return number > c.number
+	case ">=":
+		return number >= c.number
+	case "<":
+		return number < c.number
+	default:
+		return number <= c.number
+	}
+}
+
+// jsonPath finds the value at the dot separated path, objects and arrays are returned JSON encoded.
+func jsonPath(document interface{}, path string) (string, bool) {
+	value := document
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			var found bool
+			if value, found = node[key]; !found {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			value = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case nil:
+		return "", true
+	case map[string]interface{}, []interface{}:
+		encoded, _ := json.Marshal(value)
+		return string(encoded), true
+	default:
+		return fmt.Sprint(value), true
+	}
+}
+
+// ruleData parses the payload lazily, only if a condition needs it.
+type ruleData struct {
+	payload  *iface.PayloadRecord
+	parsed   *parser.Form
+	document interface{}
+	decoded  bool
+}
+
+func (d *ruleData) form() *parser.Form {
+	if d.parsed == nil {
+		var err error
+		if d.parsed, err = parser.ParseForm(d.payload); err != nil {
+			d.parsed = &parser.Form{}
+		}
+	}
+	return d.parsed
+}
+
+func (d *ruleData) json() interface{} {
+	if !d.decoded {
+		d.decoded = true
+		if err := json.Unmarshal([]byte(d.payload.Payload), &d.document); err != nil {
+			d.document = nil
+		}
+	}
+	return d.document
+}
+
+// rule applies to its targets if all of its conditions are met, a rule without conditions always applies.
+type rule struct {
+	conditions []*condition
+	targets    []string
+}
+
+// parseRule parses a rule in the form `condition && condition -> target target`. Conditions are trimmed, so patterns may contain spaces but neither start nor end with one. Kind names the rule in errors.
+func parseRule(kind, text string) (*rule, error) {
+	i := strings.LastIndex(text, ruleArrow)
+	if i < 0 {
+		return nil, errors.Errorf("%s rule %s has no %s", kind, text, ruleArrow)
+	}
+	r := &rule{targets: strings.Fields(text[i+len(ruleArrow):])}
+	if len(r.targets) == 0 {
+		return nil, errors.Errorf("%s rule %s names nothing after %s", kind, text, ruleArrow)
+	}
+	conditions := strings.TrimSpace(text[:i])
+	if len(conditions) == 0 {
+		return r, nil
+	}
+	for _, part := range strings.Split(conditions, ruleAnd) {
+		c, err := parseCondition(strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s rule %s", kind, text)
+		}
+		r.conditions = append(r.conditions, c)
+	}
+	return r, nil
+}
+
+func (r *rule) matches(payload *iface.PayloadRecord, data *ruleData) bool {
+	for _, c := range r.conditions {
+		if !c.matches(payload, data) {
+			return false
+		}
+	}
+	return true
+}
+
+// RuleNotifier passes payload to the notifiers chosen by rules. Notifiers of all matching rules fire (each once, in the configured order), no notifier fires if no rule matches. Without rules all notifiers fire.
+type RuleNotifier struct {
+	names     []string
+	notifiers []iface.PayloadNotifier
+	rules     []*rule
+}
+
+// NewRuleNotifier creates a RuleNotifier of notifiers named by names, rules may only refer to these names.
+func NewRuleNotifier(names []string, notifiers []iface.PayloadNotifier, rules []string) (*RuleNotifier, error) {
+	r := &RuleNotifier{names: names, notifiers: notifiers}
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, text := range rules {
+		rule, err := parseRule("notify", text)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range rule.targets {
+			if !known[name] {
+				return nil, errors.Errorf("notify rule %s refers to %s which is not a notifier of the route", text, name)
+			}
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Configure does nothing, the notifiers are expected to be configured already.
+func (r *RuleNotifier) Configure(*iface.Settings) error {
+	return nil
+}
+
+// Notify passes payload to the chosen notifiers, errors are collected and returned together.
+func (r *RuleNotifier) Notify(payload *iface.PayloadRecord) error {
+	chosen := r.choose(payload)
+	var messages []string
+	for i, notifier := range r.notifiers {
+		if !chosen[r.names[i]] {
+			continue
+		}
+		if err := notifier.Notify(payload); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.Errorf("error notifying of payload: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// choose returns names of the notifiers to fire.
+func (r *RuleNotifier) choose(payload *iface.PayloadRecord) map[string]bool {
+	chosen := map[string]bool{}
+	if len(r.rules) == 0 {
+		for _, name := range r.names {
+			chosen[name] = true
+		}
+		return chosen
+	}
+	if payload == nil {
+		return chosen
+	}
+	data := &ruleData{payload: payload}
+	for _, rule := range r.rules {
+		if rule.matches(payload, data) {
+			for _, name := range rule.targets {
+				chosen[name] = true
+			}
+		}
+	}
+	return chosen
+}
@@ -0,0 +1,112 @@
+package notifier
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := parseCondition("header:x-severity!=^low$")
+	assert.NoError(t, err)
+	assert.Equal(t, "header", c.subject)
+	assert.Equal(t, "X-Severity", c.name)
+	assert.Equal(t, "!=", c.op)
+	c, err = parseCondition("size>=1024")
+	assert.NoError(t, err)
+	assert.Equal(t, ">=", c.op)
+	assert.Equal(t, float64(1024), c.number)
+	c, err = parseCondition("json:alert.level<3")
+	assert.NoError(t, err)
+	assert.Equal(t, "alert.level", c.name)
+	for _, invalid := range []string{"severity", "=critical", "cookie:x=y", "json=x", "size>big", "body=(", "size!3"} {
+		_, err = parseCondition(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCondition_Matches(t *testing.T) {
+	payload := &iface.PayloadRecord{
+		Payload: `{"severity": "critical", "alert": {"level": 4, "tags": ["db", "prod"]}, "note": null}`,
+		Meta:    map[string][]string{"Content-Type": {"application/json"}, "X-Source": {"monitoring"}},
+	}
+	for rule, expected := range map[string]bool{
+		"json:severity=^critical$":  true,
+		"json:severity!=^critical$": false,
+		"json:alert.level>3":        true,
+		"json:alert.level<=3":       false,
+		"json:alert.tags.1=prod":    true,
+		"json:alert.tags.2=prod":    false,
+		"json:alert.tags=db":        true,
+		"json:note=^$":              true,
+		"json:missing!=x":           true,
+		"json:severity>1":           false,
+		"field:severity=critical":   true,
+		"header:x-source=^monitor":  true,
+		"header:x-missing=.":        false,
+		"body=(?i)CRITICAL":         true,
+		"size>10":                   true,
+		"size<10":                   false,
+	} {
+		c, err := parseCondition(rule)
+		assert.NoError(t, err, rule)
+		assert.Equal(t, expected, c.matches(payload, &ruleData{payload: payload}), rule)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := parseRule("notify", "json:note=^on call now$ && body=a{1,3}->b && size<100 -> SMTPNotifier SlackNotifier")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SMTPNotifier", "SlackNotifier"}, r.targets)
+	assert.Len(t, r.conditions, 3)
+	assert.True(t, r.conditions[0].pattern.MatchString("on call now"))
+	assert.True(t, r.conditions[1].pattern.MatchString("aa->b"))
+	for _, invalid := range []string{"body=x", "body=x ->", "body=x && && size<1 -> SMTPNotifier", "severity -> SMTPNotifier"} {
+		_, err = parseRule("notify", invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// failingNotifier fails every notification.
+type failingNotifier struct{}
+
+func (f *failingNotifier) Configure(*iface.Settings) error {
+	return nil
+}
+
+func (f *failingNotifier) Notify(*iface.PayloadRecord) error {
+	return errors.New("failed")
+}
+
+func TestRuleNotifier_Notify(t *testing.T) {
+	email, chat := &recordingNotifier{}, &recordingNotifier{}
+	r, err := NewRuleNotifier([]string{"SMTPNotifier", "SlackNotifier"}, []iface.PayloadNotifier{email, chat}, []string{
+		"json:severity=^critical$ -> SMTPNotifier SlackNotifier",
+		"json:severity=^warning$ && size<100 -> SlackNotifier",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Notify(&iface.PayloadRecord{Payload: `{"severity": "critical"}`}))
+	assert.NoError(t, r.Notify(&iface.PayloadRecord{Payload: `{"severity": "warning"}`}))
+	assert.NoError(t, r.Notify(&iface.PayloadRecord{Payload: `{"severity": "info"}`}))
+	assert.NoError(t, r.Notify(nil))
+	assert.Len(t, email.notified(), 1)
+	assert.Len(t, chat.notified(), 2)
+	// without rules all notifiers fire
+	r, err = NewRuleNotifier([]string{"SMTPNotifier", "Failing"}, []iface.PayloadNotifier{email, &failingNotifier{}}, nil)
+	assert.NoError(t, err)
+	assert.Error(t, r.Notify(&iface.PayloadRecord{Payload: "anything"}))
+	assert.Len(t, email.notified(), 2)
+	_, err = NewRuleNotifier([]string{"SMTPNotifier"}, []iface.PayloadNotifier{email}, []string{"body=x -> SlackNotifier"})
+	assert.Error(t, err)
+	_, err = NewRuleNotifier([]string{"SMTPNotifier"}, []iface.PayloadNotifier{email}, []string{"body=x SMTPNotifier"})
+	assert.Error(t, err)
+	_, err = NewRuleNotifier([]string{"SMTPNotifier"}, []iface.PayloadNotifier{email}, []string{"body=x && -> SMTPNotifier"})
+	assert.Error(t, err)
+	// rule without conditions always fires
+	r, err = NewRuleNotifier([]string{"SMTPNotifier"}, []iface.PayloadNotifier{email}, []string{"-> SMTPNotifier"})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Notify(&iface.PayloadRecord{}))
+	assert.Len(t, email.notified(), 3)
+}
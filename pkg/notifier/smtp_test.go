@@ -0,0 +1,246 @@
+package notifier
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMail is a message received by the fake SMTP server.
+type fakeMail struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	user string
+}
+
+// fakeSMTP is a minimal SMTP server for testing, it offers STARTTLS and AUTH PLAIN if asked to.
+type fakeSMTP struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	caFile      string
+	implicitTLS bool
+	startTLS    bool
+	auth        bool
+	mutex       sync.Mutex
+	mails       []fakeMail
+}
+
+func newFakeSMTP(t *testing.T, implicitTLS, startTLS, auth bool) *fakeSMTP {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake smtp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	f := &fakeSMTP{
+		tlsConfig:   &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		caFile:      filepath.Join(dir, "ca.pem"),
+		implicitTLS: implicitTLS,
+		startTLS:    startTLS,
+		auth:        auth,
+	}
+	assert.NoError(t, ioutil.WriteFile(f.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	if implicitTLS {
+		f.listener, err = tls.Listen("tcp", "127.0.0.1:0", f.tlsConfig)
+	} else {
+		f.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	assert.NoError(t, err)
+	t.Cleanup(func() { f.listener.Close() })
+	go f.serve()
+	return f
+}
+
+// settings returns settings pointing the notifier to this server.
+func (f *fakeSMTP) settings() *iface.Settings {
+	_, port, _ := net.SplitHostPort(f.listener.Addr().String())
+	return &iface.Settings{
+		SMTPServer:   "127.0.0.1",
+		SMTPPort:     port,
+		SMTPCAFile:   f.caFile,
+		SMTPFrom:     "glutton@example.com",
+		SMTPTo:       "admin@example.com",
+		SMTPPassword: "secret",
+		SMTPTimeout:  "5s",
+	}
+}
+
+func (f *fakeSMTP) received() []fakeMail {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]fakeMail{}, f.mails...)
+}
+
+func (f *fakeSMTP) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeSMTP) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	mail := fakeMail{tls: f.implicitTLS}
+	text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			text.PrintfLine("250-fake")
+			if f.startTLS && !mail.tls {
+				text.PrintfLine("250-STARTTLS")
+			}
+			if f.auth {
+				text.PrintfLine("250-AUTH PLAIN")
+			}
+			text.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			text.PrintfLine("220 go ahead")
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, text, mail.tls = tlsConn, textproto.NewConn(tlsConn), true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line[5:], "PLAIN "))
+			mail.user = strings.Split(string(credentials), "\x00")[1]
+			text.PrintfLine("235 authenticated")
+		case "MAIL":
+			mail.from = strings.Trim(line[10:], "<>")
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(line[8:], "<>"))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+			f.mutex.Lock()
+			f.mails = append(f.mails, mail)
+			f.mutex.Unlock()
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func TestSMTPNotifier_ImplicitTLS(t *testing.T) {
+	server := newFakeSMTP(t, true, false, true)
+	settings := server.settings()
+	settings.SMTPSecurity = "tls"
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(settings))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	mails := server.received()
+	assert.Len(t, mails, 1)
+	assert.True(t, mails[0].tls)
+	assert.Equal(t, "glutton@example.com", mails[0].user)
+	assert.Equal(t, []string{"admin@example.com"}, mails[0].to)
+	assert.Contains(t, mails[0].data, "test payload")
+}
+
+func TestSMTPNotifier_StartTLS(t *testing.T) {
+	server := newFakeSMTP(t, false, true, true)
+	settings := server.settings()
+	settings.SMTPUseTLS = true
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(settings))
+	assert.Equal(t, "starttls", n.Security)
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	mails := server.received()
+	assert.Len(t, mails, 1)
+	assert.True(t, mails[0].tls)
+	// untrusted certificate
+	settings.SMTPCAFile = ""
+	assert.NoError(t, n.Configure(settings))
+	assert.Error(t, n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	assert.Len(t, server.received(), 1)
+}
+
+func TestSMTPNotifier_StartTLSNotOffered(t *testing.T) {
+	server := newFakeSMTP(t, false, false, true)
+	settings := server.settings()
+	settings.SMTPSecurity = "starttls"
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(settings))
+	err := n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not offer STARTTLS")
+	assert.Empty(t, server.received())
+}
+
+func TestSMTPNotifier_NoAuth(t *testing.T) {
+	server := newFakeSMTP(t, false, false, false)
+	settings := server.settings()
+	settings.SMTPSecurity = "none"
+	settings.SMTPAuth = "none"
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(settings))
+	assert.NoError(t, n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	mails := server.received()
+	assert.Len(t, mails, 1)
+	assert.False(t, mails[0].tls)
+	assert.Empty(t, mails[0].user)
+	// authentication required but not offered
+	settings.SMTPAuth = "plain"
+	assert.NoError(t, n.Configure(settings))
+	assert.Error(t, n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+}
+
+func TestSMTPNotifier_Configure(t *testing.T) {
+	n := new(SMTPNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{SMTPUseTLS: true, SMTPPort: "465"}))
+	assert.Equal(t, "tls", n.Security)
+	assert.NoError(t, n.Configure(&iface.Settings{SMTPPort: "25"}))
+	assert.Equal(t, "opportunistic", n.Security)
+	assert.Error(t, n.Configure(&iface.Settings{SMTPSecurity: "ssl"}))
+	assert.Error(t, n.Configure(&iface.Settings{SMTPAuth: "login"}))
+	assert.Error(t, n.Configure(&iface.Settings{SMTPCAFile: "/nonexistent"}))
+}
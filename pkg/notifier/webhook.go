@@ -0,0 +1,224 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	// webhookEventType is the type of events sent for received payload.
+	webhookEventType = "payload.received"
+	// WebhookEventHeader carries the type of the event.
+	WebhookEventHeader = "X-Glutton-Event"
+	// WebhookDeliveryHeader carries the id of the delivery, the same for all attempts.
+	WebhookDeliveryHeader = "X-Glutton-Delivery"
+	// WebhookTimestampHeader carries the unix time the request was signed at.
+	WebhookTimestampHeader = "X-Glutton-Timestamp"
+	defaultSignatureHeader = "X-Glutton-Signature"
+)
+
+// webhookEvent is the JSON body posted to webhooks.
+type webhookEvent struct {
+	ID      string               `json:"id"`
+	Type    string               `json:"type"`
+	Name    string               `json:"name"`
+	Route   string               `json:"route"`
+	Created time.Time            `json:"created"`
+	Payload *iface.PayloadRecord `json:"payload"`
+}
+
+// webhookDelivery is a line of the delivery log.
+type webhookDelivery struct {
+	ID       string    `json:"delivery"`
+	Event    string    `json:"event"`
+	URL      string    `json:"url"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// WebhookNotifier posts a JSON event describing the payload to webhooks. Requests are signed with HMAC-SHA256 of the timestamp and the body, failed deliveries are retried and every delivery is logged.
+type WebhookNotifier struct {
+	urls            []string
+	secret          []byte
+	signatureHeader string
+	name            string
+	route           string
+	retries         int
+	backoff         time.Duration
+	timeout         time.Duration
+	client          *http.Client
+	mutex           sync.Mutex
+	log             *os.File
+	debug           bool
+}
+
+// Configure configures the WebhookNotifier.
+// Namely the following params are used:
+// * WebhookURLs - where to post events
+// * WebhookSecret, WebhookSignatureHeader - key to sign requests with (unsigned if empty) and the header carrying the signature
+// * WebhookTimeout, WebhookRetries, WebhookRetryBackoff - how long a delivery may take, attempts and pauses included, as the request waits for it (unless notified async), number of retries and the (doubling) pause between them
+// * WebhookLog - file to append the delivery log to (JSON lines), none if empty
+func (w *WebhookNotifier) Configure(settings *iface.Settings) (err error) {
+	if len(settings.WebhookURLs) == 0 {
+		return errors.New("webhook urls not configured")
+	}
+	if w.timeout, err = iface.ParseDuration(settings.WebhookTimeout, 10*time.Second); err != nil {
+		return err
+	}
+	if w.backoff, err = iface.ParseDuration(settings.WebhookRetryBackoff, time.Second); err != nil {
+		return err
+	}
+	w.urls = settings.WebhookURLs
+	w.secret = []byte(settings.WebhookSecret)
+	w.signatureHeader = settings.WebhookSignatureHeader
+	if len(w.signatureHeader) == 0 {
+		w.signatureHeader = defaultSignatureHeader
+	}
+	w.name = settings.Name
+	w.route = settings.URI
+	w.retries = settings.WebhookRetries
+	w.client = &http.Client{}
+	w.debug = settings.Debug
+	if len(settings.WebhookLog) > 0 {
+		if w.log, err = os.OpenFile(settings.WebhookLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			return errors.Wrapf(err, "error opening webhook log %s", settings.WebhookLog)
+		}
+	}
+	return nil
+}
+
+// Notify delivers the event to every webhook, it fails if any of the deliveries does.
+func (w *WebhookNotifier) Notify(payload *iface.PayloadRecord) error {
+	event := &webhookEvent{ID: uuid.NewString(), Type: webhookEventType, Name: w.name, Route: w.route, Created: time.Now().UTC(), Payload: payload}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "error encoding webhook event")
+	}
+	var failed []string
+	for _, url := range w.urls {
+		delivery := w.deliver(url, event.ID, body)
+		w.record(delivery)
+		if len(delivery.Error) > 0 {
+			failed = append(failed, url+": "+delivery.Error)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("error delivering webhook event %s to %s", event.ID, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// deliver posts the event to the url, retrying on network errors and 5xx/429 responses until the timeout.
+func (w *WebhookNotifier) deliver(url, event string, body []byte) *webhookDelivery {
+	delivery := &webhookDelivery{ID: uuid.NewString(), Event: event, URL: url, Time: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+	backoff := w.backoff
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				delivery.Error += " (timeout exceeded)"
+				return delivery
+			}
+			backoff *= 2
+		}
+		delivery.Attempts = attempt + 1
+		retry, err := w.post(ctx, delivery, body)
+		if err == nil {
+			delivery.Error = ""
+			return delivery
+		}
+		delivery.Error = err.Error()
+		if w.debug {
+			log.Printf("WebhookNotifier_Notify: attempt %d of delivery %s failed %+v", attempt+1, delivery.ID, err)
+		}
+		if !retry {
+			break
+		}
+	}
+	return delivery
+}
+
+// post makes a single attempt, it returns whether a failure is worth retrying.
+func (w *WebhookNotifier) post(ctx context.Context, delivery *webhookDelivery, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "error creating request")
+	}
+	timestamp := fmt.Sprint(time.Now().Unix())
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "glutton")
+	req.Header.Set(WebhookEventHeader, webhookEventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if len(w.secret) > 0 {
+		req.Header.Set(w.signatureHeader, Sign(w.secret, timestamp, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	delivery.Status = resp.StatusCode
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return true, errors.Errorf("webhook replied %s", resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		return false, errors.Errorf("webhook replied %s", resp.Status)
+	}
+	return false, nil
+}
+
+// record appends the delivery to the log.
+func (w *WebhookNotifier) record(delivery *webhookDelivery) {
+	if w.log == nil {
+		return
+	}
+	line, _ := json.Marshal(delivery)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, err := w.log.Write(append(line, '\n')); err != nil {
+		log.Printf("error writing webhook log %+v", err)
+	}
+}
+
+// Close closes the delivery log.
+func (w *WebhookNotifier) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.log == nil {
+		return nil
+	}
+	return w.log.Close()
+}
+
+// Sign computes the signature of a webhook request, `sha256=` followed by hex encoded HMAC-SHA256 of the timestamp, a dot and the body. Receivers should compare it in constant time and reject old timestamps.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
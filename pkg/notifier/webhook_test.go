@@ -0,0 +1,101 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	var deliveries []string
+	attempts := 0
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "payload.received", r.Header.Get(WebhookEventHeader))
+		assert.Equal(t, Sign([]byte("secret"), r.Header.Get(WebhookTimestampHeader), body), r.Header.Get("X-Signature"))
+		deliveries = append(deliveries, r.Header.Get(WebhookDeliveryHeader))
+		event := webhookEvent{}
+		assert.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, "/contact", event.Route)
+		assert.Equal(t, "test payload", event.Payload.Payload)
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "webhook.log")
+	n := new(WebhookNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{URI: "/contact", WebhookURLs: []string{flaky.URL, gone.URL}, WebhookSecret: "secret",
+		WebhookSignatureHeader: "X-Signature", WebhookRetries: 2, WebhookRetryBackoff: "1ms", WebhookLog: logFile}))
+	err = n.Notify(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), gone.URL)
+	assert.NotContains(t, err.Error(), flaky.URL)
+	assert.NoError(t, n.Close())
+	// retries keep the delivery id
+	assert.Len(t, deliveries, 2)
+	assert.Equal(t, deliveries[0], deliveries[1])
+	content, err := ioutil.ReadFile(logFile)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	logged := []webhookDelivery{{}, {}}
+	for i, line := range lines {
+		assert.NoError(t, json.Unmarshal([]byte(line), &logged[i]))
+	}
+	assert.Equal(t, deliveries[0], logged[0].ID)
+	assert.Equal(t, 2, logged[0].Attempts)
+	assert.Equal(t, http.StatusOK, logged[0].Status)
+	assert.Empty(t, logged[0].Error)
+	assert.Equal(t, logged[0].Event, logged[1].Event)
+	// client errors are not retried
+	assert.Equal(t, 1, logged[1].Attempts)
+	assert.Equal(t, http.StatusGone, logged[1].Status)
+	assert.NotEmpty(t, logged[1].Error)
+}
+
+func TestWebhookNotifier_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	n := new(WebhookNotifier)
+	assert.NoError(t, n.Configure(&iface.Settings{WebhookURLs: []string{server.URL}, WebhookRetries: 10, WebhookRetryBackoff: "20ms", WebhookTimeout: "100ms"}))
+	start := time.Now()
+	err := n.Notify(&iface.PayloadRecord{Payload: "test payload"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout exceeded")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWebhookNotifier_Configure(t *testing.T) {
+	n := new(WebhookNotifier)
+	assert.Error(t, n.Configure(&iface.Settings{}))
+	assert.Error(t, n.Configure(&iface.Settings{WebhookURLs: []string{"http://localhost"}, WebhookTimeout: "soon"}))
+	assert.NoError(t, n.Configure(&iface.Settings{WebhookURLs: []string{"http://localhost"}}))
+	assert.Equal(t, "X-Glutton-Signature", n.signatureHeader)
+	assert.NoError(t, n.Close())
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1600000000.{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=1e56a11da123b137c26fa37b7c222060bdf22988aa9b3248c31244f8b2ef4a28", Sign([]byte("secret"), "1600000000", []byte("{}")))
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/pkg/errors"
+)
+
+// maxFormMemory limits the memory used by a multipart form, larger uploads are read from temporary files.
+const maxFormMemory = 32 << 20
+
+// File is a file uploaded with a multipart form.
+type File struct {
+	Field       string
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Form holds fields and files of a payload.
+type Form struct {
+	Fields url.Values
+	Files  []File
+}
+
+// Names returns names of the fields sorted.
+func (f *Form) Names() []string {
+	names := make([]string, 0, len(f.Fields))
+	for name := range f.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseForm parses the payload by its content type. Url encoded and multipart forms are supported, as well as JSON objects (their top level members become fields). Other payload yields an empty form.
+func ParseForm(payload *iface.PayloadRecord) (*Form, error) {
+	form := &Form{Fields: url.Values{}}
+	contentType := ""
+	if values := payload.Meta["Content-Type"]; len(values) > 0 {
+		contentType = values[0]
+	}
+	if len(contentType) == 0 {
+		return form, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing content type %s", contentType)
+	}
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		if form.Fields, err = url.ParseQuery(payload.Payload); err != nil {
+			return nil, errors.Wrap(err, "error parsing form")
+		}
+	case mediaType == "multipart/form-data":
+		if err = parseMultipart(form, payload.Payload, params["boundary"]); err != nil {
+			return nil, err
+		}
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		members := map[string]interface{}{}
+		if err = json.Unmarshal([]byte(payload.Payload), &members); err != nil {
+			// not an object, nothing to parse
+			return form, nil
+		}
+		for name, value := range members {
+			switch value := value.(type) {
+			case string:
+				form.Fields.Add(name, value)
+			case nil:
+				form.Fields.Add(name, "")
+			case map[string]interface{}, []interface{}:
+				encoded, _ := json.Marshal(value)
+				form.Fields.Add(name, string(encoded))
+			default:
+				form.Fields.Add(name, fmt.Sprint(value))
+			}
+		}
+	}
+	return form, nil
+}
+
+func parseMultipart(form *Form, body, boundary string) error {
+	if len(boundary) == 0 {
+		return errors.New("multipart form without boundary")
+	}
+	multipartForm, err := multipart.NewReader(strings.NewReader(body), boundary).ReadForm(maxFormMemory)
+	if err != nil {
+		return errors.Wrap(err, "error parsing multipart form")
+	}
+	defer multipartForm.RemoveAll()
+	form.Fields = multipartForm.Value
+	for field, headers := range multipartForm.File {
+		for _, header := range headers {
+			f, err := header.Open()
+			if err != nil {
+				return errors.Wrapf(err, "error reading uploaded file %s", header.Filename)
+			}
+			data, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return errors.Wrapf(err, "error reading uploaded file %s", header.Filename)
+			}
+			form.Files = append(form.Files, File{Field: field, Filename: header.Filename, ContentType: header.Header.Get("Content-Type"), Data: data})
+		}
+	}
+	sort.SliceStable(form.Files, func(i, j int) bool { return form.Files[i].Field < form.Files[j].Field })
+	return nil
+}
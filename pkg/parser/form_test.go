@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"bytes"
+	"mime/multipart"
+	"testing"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForm(t *testing.T) {
+	form, err := ParseForm(&iface.PayloadRecord{
+		Payload: "name=John+Doe&topic=a&topic=b",
+		Meta:    map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"name", "topic"}, form.Names())
+	assert.Equal(t, []string{"a", "b"}, form.Fields["topic"])
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("name", "John")
+	part, _ := w.CreateFormFile("cv", "cv.txt")
+	part.Write([]byte("curriculum"))
+	w.Close()
+	form, err = ParseForm(&iface.PayloadRecord{Payload: body.String(), Meta: map[string][]string{"Content-Type": {w.FormDataContentType()}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "John", form.Fields.Get("name"))
+	assert.Len(t, form.Files, 1)
+	assert.Equal(t, File{Field: "cv", Filename: "cv.txt", ContentType: "application/octet-stream", Data: []byte("curriculum")}, form.Files[0])
+
+	form, err = ParseForm(&iface.PayloadRecord{
+		Payload: `{"name":"John","age":42,"tags":["a"],"none":null}`,
+		Meta:    map[string][]string{"Content-Type": {"application/json; charset=utf-8"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "John", form.Fields.Get("name"))
+	assert.Equal(t, "42", form.Fields.Get("age"))
+	assert.Equal(t, `["a"]`, form.Fields.Get("tags"))
+
+	form, err = ParseForm(&iface.PayloadRecord{Payload: "plain", Meta: map[string][]string{"Content-Type": {"text/plain"}}})
+	assert.NoError(t, err)
+	assert.Empty(t, form.Fields)
+	_, err = ParseForm(&iface.PayloadRecord{Payload: "x", Meta: map[string][]string{"Content-Type": {"multipart/form-data"}}})
+	assert.Error(t, err)
+}
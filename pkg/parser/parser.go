@@ -16,17 +16,31 @@ type SimpleParser struct {
 // Parse reads request and builds a payload from it.
 func (s *SimpleParser) Parse(req *http.Request) (*iface.PayloadRecord, error) {
 	payload := &iface.PayloadRecord{}
+	started := time.Now()
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return nil, errors.Wrap(err, "error reading payload")
 	}
 	payload.Payload = string(body)
 	payload.Timestamp = time.Now()
+	payload.Received = payload.Timestamp.Sub(started)
 	payload.Remote = req.RemoteAddr
 	payload.Meta = req.Header
+	payload.Method = req.Method
+	payload.URL = requestURL(req)
 	return payload, nil
 }
 
+// requestURL rebuilds the absolute URL of a server request.
+func requestURL(req *http.Request) string {
+	u := *req.URL
+	u.Scheme, u.Host = "http", req.Host
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
 // Configure initilizes the instance of parser.
 func (s *SimpleParser) Configure(*iface.Settings) error {
 	return nil
@@ -0,0 +1,150 @@
+package saver
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+// batchRetain is how many batches worth of records are kept for the next flush while flushing fails, older records are dropped.
+const batchRetain = 10
+
+// retryError is returned by a flush that failed for some of the records only, just those are kept for the next flush.
+type retryError struct {
+	records []*iface.PayloadRecord
+	err     error
+}
+
+func (r *retryError) Error() string {
+	return r.err.Error()
+}
+
+// batcher collects records and hands them over to flush once size of them are collected or interval elapses, whatever comes first. Records of a failed flush are kept and flushed again with the next batch.
+type batcher struct {
+	name     string
+	size     int
+	interval time.Duration
+	flush    func([]*iface.PayloadRecord) error
+	mutex    sync.Mutex
+	records  []*iface.PayloadRecord
+	failed   error
+	dropped  int
+	done     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// newBatcher creates a batcher and starts its interval flushing. Flush errors of the interval flushing are only logged, name is used to tell the batchers apart in the log.
+func newBatcher(name string, size int, interval time.Duration, flush func([]*iface.PayloadRecord) error) *batcher {
+	b := &batcher{
+		name:     name,
+		size:     size,
+		interval: interval,
+		flush:    flush,
+		done:     make(chan struct{}),
+	}
+	if interval > 0 {
+		b.stopped.Add(1)
+		go b.run(interval)
+	}
+	return b
+}
+
+// Add appends a record to the current batch, flushing it if full. While flushing fails only the interval flushing retries, if there is one.
+func (b *batcher) Add(payload *iface.PayloadRecord) error {
+	b.mutex.Lock()
+	b.records = append(b.records, payload)
+	b.retain()
+	if len(b.records) < b.size || b.failed != nil && b.interval > 0 {
+		b.mutex.Unlock()
+		return nil
+	}
+	records := b.take()
+	b.mutex.Unlock()
+	return b.flushRecords(records)
+}
+
+// Flush flushes the current batch, if there is any.
+func (b *batcher) Flush() error {
+	b.mutex.Lock()
+	records := b.take()
+	b.mutex.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	return b.flushRecords(records)
+}
+
+// Close stops the interval flushing and flushes what's left.
+func (b *batcher) Close() error {
+	close(b.done)
+	b.stopped.Wait()
+	return b.Flush()
+}
+
+// Health fails while records of a failed flush wait for the next one.
+func (b *batcher) Health() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failed == nil {
+		return nil
+	}
+	return errors.Wrapf(b.failed, "%d records waiting, %d dropped since flushing failed", len(b.records), b.dropped)
+}
+
+// flushRecords flushes records, they are put back in front of the current batch if that fails.
+func (b *batcher) flushRecords(records []*iface.PayloadRecord) error {
+	err := b.flush(records)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err == nil {
+		b.failed, b.dropped = nil, 0
+		return nil
+	}
+	b.failed = err
+	if retry, ok := err.(*retryError); ok {
+		records = retry.records
+	}
+	b.records = append(records[:len(records):len(records)], b.records...)
+	b.retain()
+	return errors.Wrapf(err, "%d records kept for the next flush", len(b.records))
+}
+
+// retain drops the oldest records over the limit of kept records, must be called with the mutex held.
+func (b *batcher) retain() {
+	limit := b.size * batchRetain
+	if b.size < 1 {
+		limit = batchRetain
+	}
+	if over := len(b.records) - limit; over > 0 && b.failed != nil {
+		b.records = b.records[over:]
+		b.dropped += over
+		log.Printf("%s: flushing fails, %d oldest records dropped", b.name, over)
+	}
+}
+
+// take returns the current batch and starts a new one, must be called with the mutex held.
+func (b *batcher) take() []*iface.PayloadRecord {
+	records := b.records
+	b.records = nil
+	return records
+}
+
+func (b *batcher) run(interval time.Duration) {
+	defer b.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.Printf("%s: error flushing batch %+v", b.name, err)
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package saver
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher_Interval(t *testing.T) {
+	var flushed int64
+	b := newBatcher("test", 100, 10*time.Millisecond, func(records []*iface.PayloadRecord) error {
+		atomic.AddInt64(&flushed, int64(len(records)))
+		return nil
+	})
+	assert.NoError(t, b.Add(&iface.PayloadRecord{}))
+	assert.NoError(t, b.Add(&iface.PayloadRecord{}))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&flushed))
+	assert.NoError(t, b.Add(&iface.PayloadRecord{}))
+	assert.NoError(t, b.Close())
+	assert.Equal(t, int64(3), atomic.LoadInt64(&flushed))
+}
+
+func TestBatcher_Failed(t *testing.T) {
+	var flushed []*iface.PayloadRecord
+	failing := true
+	b := newBatcher("test", 2, 0, func(records []*iface.PayloadRecord) error {
+		if failing {
+			return errors.New("database gone")
+		}
+		flushed = append(flushed, records...)
+		return nil
+	})
+	assert.NoError(t, b.Add(&iface.PayloadRecord{Payload: "0"}))
+	assert.Error(t, b.Add(&iface.PayloadRecord{Payload: "1"}))
+	assert.Error(t, b.Health())
+	for i := 2; i < 30; i++ {
+		b.Add(&iface.PayloadRecord{Payload: fmt.Sprint(i)})
+	}
+	// at most ten batches are kept
+	assert.Len(t, b.records, 20)
+	failing = false
+	assert.NoError(t, b.Close())
+	assert.NoError(t, b.Health())
+	assert.Len(t, flushed, 20)
+	assert.Equal(t, "10", flushed[0].Payload)
+	assert.Equal(t, "29", flushed[19].Payload)
+}
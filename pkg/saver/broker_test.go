@@ -0,0 +1,350 @@
+package saver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// the kafka test runs against a fake transport, nats and amqp ones against brokers started by the tests
+
+// testKafkaTransport answers metadata and produce requests as a single broker holding one partition of every topic.
+type testKafkaTransport struct {
+	mutex   sync.Mutex
+	records []kafkaRecord
+	down    bool
+}
+
+type kafkaRecord struct {
+	topic      string
+	key, value string
+}
+
+func (f *testKafkaTransport) RoundTrip(ctx context.Context, addr net.Addr, request kafka.Request) (kafka.Response, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	switch request := request.(type) {
+	case *metadata.Request:
+		response := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: "localhost", Port: 9092}}}
+		for _, topic := range request.TopicNames {
+			response.Topics = append(response.Topics, metadata.ResponseTopic{Name: topic, Partitions: []metadata.ResponsePartition{{LeaderID: 1}}})
+		}
+		return response, nil
+	case *produce.Request:
+		response := &produce.Response{}
+		for _, topic := range request.Topics {
+			for _, partition := range topic.Partitions {
+				for {
+					record, err := partition.RecordSet.Records.ReadRecord()
+					if err != nil {
+						break
+					}
+					key, _ := protocol.ReadAll(record.Key)
+					value, _ := protocol.ReadAll(record.Value)
+					f.records = append(f.records, kafkaRecord{topic.Topic, string(key), string(value)})
+				}
+				response.Topics = append(response.Topics, produce.ResponseTopic{Topic: topic.Topic, Partitions: []produce.ResponsePartition{{Partition: partition.Partition}}})
+			}
+		}
+		return response, nil
+	}
+	return nil, errors.New("unexpected request")
+}
+
+func TestKafkaSaver_Save(t *testing.T) {
+	transport := &testKafkaTransport{}
+	k := new(KafkaSaver)
+	err := k.Configure(&iface.Settings{URI: "save", KafkaBrokers: []string{"localhost:9092"}, KafkaTopic: "glutton-{{.Route}}", KafkaTimeout: "1s"})
+	assert.NoError(t, err)
+	k.writer.Transport = transport
+	defer k.Close()
+	assert.NoError(t, k.Health())
+	assert.NoError(t, k.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	assert.Len(t, transport.records, 1)
+	assert.Equal(t, "glutton-save", transport.records[0].topic)
+	assert.Equal(t, "save", transport.records[0].key)
+	record := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(transport.records[0].value), &record))
+	assert.Equal(t, "test payload", record["payload"])
+	transport.mutex.Lock()
+	transport.down = true
+	transport.mutex.Unlock()
+	assert.Error(t, k.Health())
+	assert.Error(t, k.Save(&iface.PayloadRecord{Payload: "lost payload", Timestamp: time.Now()}))
+}
+
+func TestKafkaSaver_Configure(t *testing.T) {
+	assert.Error(t, new(KafkaSaver).Configure(&iface.Settings{}))
+	assert.Error(t, new(KafkaSaver).Configure(&iface.Settings{KafkaBrokers: []string{"localhost:9092"}, KafkaAcks: "some"}))
+	assert.NoError(t, new(KafkaSaver).Configure(&iface.Settings{KafkaBrokers: []string{"localhost:9092"}}))
+}
+
+func TestNATSSaver_Save(t *testing.T) {
+	server := natsserver.RunRandClientPortServer()
+	defer server.Shutdown()
+	conn, err := nats.Connect(server.ClientURL())
+	assert.NoError(t, err)
+	defer conn.Close()
+	subscription, err := conn.SubscribeSync("glutton.v1.contact")
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Flush())
+	n := new(NATSSaver)
+	assert.NoError(t, n.Configure(&iface.Settings{URI: "/v1/contact", NATSURL: server.ClientURL()}))
+	defer n.Close()
+	assert.NoError(t, n.Health())
+	assert.NoError(t, n.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	message, err := subscription.NextMsg(time.Second)
+	assert.NoError(t, err)
+	record := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(message.Data, &record))
+	assert.Equal(t, "/v1/contact", record["route"])
+	assert.Equal(t, "test payload", record["payload"])
+	n.subjectTemplate, _ = parseTemplate("nats subject", "glutton..{{.Route}}", "")
+	assert.Error(t, n.Save(&iface.PayloadRecord{Payload: "test payload"}))
+}
+
+func TestNATSSaver_JetStream(t *testing.T) {
+	options := natsserver.DefaultTestOptions
+	options.Port = -1
+	options.JetStream = true
+	options.StoreDir = t.TempDir()
+	server := natsserver.RunServer(&options)
+	defer server.Shutdown()
+	conn, err := nats.Connect(server.ClientURL())
+	assert.NoError(t, err)
+	defer conn.Close()
+	jetStream, err := conn.JetStream()
+	assert.NoError(t, err)
+	_, err = jetStream.AddStream(&nats.StreamConfig{Name: "glutton", Subjects: []string{"glutton.>"}})
+	assert.NoError(t, err)
+	n := new(NATSSaver)
+	assert.NoError(t, n.Configure(&iface.Settings{URI: "save", NATSURL: server.ClientURL(), NATSJetStream: true, NATSTimeout: "2s"}))
+	defer n.Close()
+	assert.NoError(t, n.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	info, err := jetStream.StreamInfo("glutton")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), info.State.Msgs)
+	// no stream takes this subject, so the publish isn't acknowledged
+	n.subjectTemplate, _ = parseTemplate("nats subject", "elsewhere.{{.Route}}", "")
+	assert.Error(t, n.Save(&iface.PayloadRecord{Payload: "test payload"}))
+}
+
+func TestSubjectTokens(t *testing.T) {
+	assert.Equal(t, "v1.contact", subjectTokens("/v1/contact/"))
+	assert.Equal(t, "save", subjectTokens("save"))
+	assert.Equal(t, "a_b.c_d", subjectTokens("a.b/c d"))
+	assert.Equal(t, "_", subjectTokens("/"))
+}
+
+func TestBrokerSavers_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	n := new(NATSSaver)
+	assert.NoError(t, n.Configure(&iface.Settings{URI: "save", NATSURL: "nats://" + address, NATSTimeout: "100ms"}))
+	assert.Error(t, n.Health())
+	assert.Error(t, n.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	assert.NoError(t, n.Close())
+	a := new(AMQPSaver)
+	assert.NoError(t, a.Configure(&iface.Settings{URI: "save", AMQPURL: "amqp://guest:guest@" + address + "/", AMQPTimeout: "100ms"}))
+	assert.Error(t, a.Health())
+	assert.Error(t, a.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	assert.NoError(t, a.Close())
+}
+
+func TestAMQPSaver_Save(t *testing.T) {
+	broker := newTestAMQPBroker(t)
+	defer broker.Close()
+	a := new(AMQPSaver)
+	err := a.Configure(&iface.Settings{URI: "save", AMQPURL: broker.url, AMQPExchange: "glutton", AMQPConfirm: true, AMQPPersistent: true, AMQPTimeout: "2s"})
+	assert.NoError(t, err)
+	assert.NoError(t, a.Health())
+	assert.NoError(t, a.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	assert.NoError(t, a.Save(&iface.PayloadRecord{Payload: "another payload", Timestamp: time.Now()}))
+	published := broker.messages()
+	assert.Len(t, published, 2)
+	assert.Equal(t, "glutton", published[0].exchange)
+	assert.Equal(t, "save", published[0].routingKey)
+	record := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(published[1].body, &record))
+	assert.Equal(t, "another payload", record["payload"])
+	broker.mutex.Lock()
+	broker.nack = true
+	broker.mutex.Unlock()
+	assert.Error(t, a.Save(&iface.PayloadRecord{Payload: "refused payload"}))
+	assert.NoError(t, a.Close())
+	assert.Error(t, a.Health())
+}
+
+// testAMQPMessage is a message published to the testAMQPBroker.
+type testAMQPMessage struct {
+	exchange   string
+	routingKey string
+	body       []byte
+}
+
+// testAMQPBroker speaks just enough AMQP 0-9-1 to take publishing with confirms.
+type testAMQPBroker struct {
+	t         *testing.T
+	listener  net.Listener
+	url       string
+	mutex     sync.Mutex
+	published []testAMQPMessage
+	nack      bool
+}
+
+func newTestAMQPBroker(t *testing.T) *testAMQPBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	b := &testAMQPBroker{t: t, listener: listener, url: "amqp://guest:guest@" + listener.Addr().String() + "/"}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *testAMQPBroker) Close() {
+	b.listener.Close()
+}
+
+func (b *testAMQPBroker) messages() []testAMQPMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.published
+}
+
+func (b *testAMQPBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil || string(header) != "AMQP\x00\x00\x09\x01" {
+		return
+	}
+	// connection.start: version 0-9, no properties, PLAIN mechanism, en_US locale
+	b.method(conn, 0, 10, 10, []byte{0, 9}, table(), longString("PLAIN"), longString("en_US"))
+	var (
+		publish *testAMQPMessage
+		size    uint64
+		tag     uint64
+	)
+	for {
+		kind, channel, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch kind {
+		case 1:
+			class, method := binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:])
+			switch {
+			case class == 10 && method == 11: // start-ok
+				b.method(conn, 0, 10, 30, uint16Bytes(0), uint32Bytes(131072), uint16Bytes(0))
+			case class == 10 && method == 40: // open
+				b.method(conn, 0, 10, 41, []byte{0})
+			case class == 10 && method == 50: // close
+				b.method(conn, 0, 10, 51)
+				return
+			case class == 20 && method == 10: // channel.open
+				b.method(conn, channel, 20, 11, longString(""))
+			case class == 20 && method == 40: // channel.close
+				b.method(conn, channel, 20, 41)
+			case class == 85 && method == 10: // confirm.select
+				b.method(conn, channel, 85, 11)
+			case class == 60 && method == 40: // basic.publish
+				args := payload[6:]
+				exchange := string(args[1 : 1+args[0]])
+				args = args[1+args[0]:]
+				publish = &testAMQPMessage{exchange: exchange, routingKey: string(args[1 : 1+args[0]])}
+			}
+		case 2:
+			size = binary.BigEndian.Uint64(payload[4:])
+		case 3:
+			publish.body = append(publish.body, payload...)
+		}
+		if publish != nil && kind != 1 && uint64(len(publish.body)) == size {
+			tag++
+			b.mutex.Lock()
+			nack := b.nack
+			if !nack {
+				b.published = append(b.published, *publish)
+			}
+			b.mutex.Unlock()
+			// basic.ack or basic.nack (with requeue off)
+			if nack {
+				b.method(conn, channel, 60, 120, uint64Bytes(tag), []byte{0})
+			} else {
+				b.method(conn, channel, 60, 80, uint64Bytes(tag), []byte{0})
+			}
+			publish = nil
+		}
+	}
+}
+
+// method writes a method frame.
+func (b *testAMQPBroker) method(conn net.Conn, channel, class, method uint16, args ...[]byte) {
+	payload := append(uint16Bytes(class), uint16Bytes(method)...)
+	payload = append(payload, bytes.Join(args, nil)...)
+	frame := append([]byte{1}, uint16Bytes(channel)...)
+	frame = append(frame, uint32Bytes(uint32(len(payload)))...)
+	frame = append(frame, payload...)
+	if _, err := conn.Write(append(frame, 0xce)); err != nil {
+		b.t.Logf("error writing amqp frame %+v", err)
+	}
+}
+
+func readFrame(conn net.Conn) (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:])+1)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:len(payload)-1], nil
+}
+
+func table() []byte {
+	return uint32Bytes(0)
+}
+
+func longString(s string) []byte {
+	return append(uint32Bytes(uint32(len(s))), s...)
+}
+
+func uint16Bytes(n uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, n)
+}
+
+func uint32Bytes(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+func uint64Bytes(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
@@ -1,33 +1,377 @@
 package saver
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
+	// database drivers supported by the DatabaseSaver
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 
 	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/seal"
 )
 
-const defaultLayout = "INSERT INTO payload(ts, remote, meta, payload) VALUES ($1, $2, $3, $4)"
+const (
+	defaultTable = "payload"
+	// defaultLayout matches the table layout used before glutton could create tables itself.
+	defaultLayout = "INSERT INTO %s(ts, remote, meta, payload) VALUES (:timestamp, :remote, :meta, :payload)"
+	// migratedLayout fills all columns of a table created by glutton.
+	migratedLayout = "INSERT INTO %s(route, ts, remote, method, meta, payload) VALUES (:route, :timestamp, :remote, :method, :meta, :payload)"
+	// startupContinue keeps glutton running even if the database can't be reached on start.
+	startupContinue = "continue"
+	// startupFail stops glutton if the database can't be reached on start.
+	startupFail = "fail"
+	// batchInsert flushes batches using multi-row inserts.
+	batchInsert = "insert"
+	// batchCopy flushes batches using postgres COPY.
+	batchCopy = "copy"
+)
+
+// recordFields are the named placeholders available in sql_layout.
+var recordFields = map[string]bool{"route": true, "timestamp": true, "remote": true, "method": true, "meta": true, "payload": true}
+
+// legacyFields is the argument order of layouts written with positional placeholders only ($1 .. $4).
+var legacyFields = []string{"timestamp", "remote", "meta", "payload"}
 
 // DatabaseSaver can save payload to database.
 type DatabaseSaver struct {
-	db     *sql.DB
-	layout string
+	db          *sql.DB
+	dialect     *dialect
+	table       string
+	rawLayout   string
+	layout      string
+	fields      []string
+	positional  bool
+	route       string
+	pingTimeout time.Duration
+	batchMode   string
+	copyTable   string
+	copyColumns []string
+	copyFields  []string
+	batcher     *batcher
+	sealer      *seal.Sealer
+	janitor     *janitor
+	tableKey    string
+	routeColumn bool
+	debug       bool
 }
 
 // Configure configures this instance of DatabaseSaver.
+// Namely the following params are used:
+// * SQLDriver, SQLConnectionString - passed to sql.Open
+// * SQLDialect - postgres, mysql or sqlite, derived from the driver if empty
+// * SQLTable - table to create (if SQLAutoMigrate is set) and to insert into by default
+// * SQLayout - insert statement using named placeholders
+// * SQLStartupPolicy - `continue` (default, as before the database was verified) or `fail` when the database isn't usable on start
+// * SQLPingTimeout, SQLMaxOpenConns, SQLMaxIdleConns, SQLConnMaxLifetime - connection pool tuning
+// * SQLBatchSize, SQLFlushInterval, SQLBatchMode - buffering of records, batching is off unless the size is greater than one. COPY needs a layout inserting named placeholders only
+// * EncryptionMasterKey, EncryptionRecipient - seal the payload column if either is set
+// * RetentionMaxAge, RetentionMaxCount, RetentionMaxBytes, RetentionInterval - periodic removal of old records, the table needs ts and payload columns (checked on start if the database is ready). Refused if another route writes to the same table and the route isn't stored
 func (ds *DatabaseSaver) Configure(settings *iface.Settings) (err error) {
+	ds.route = settings.URI
+	ds.debug = settings.Debug
+	if ds.sealer, err = newSealer(settings); err != nil {
+		return err
+	}
+	ds.dialect, err = dialectFor(settings.SQLDialect, settings.SQLDriver)
+	if err != nil {
+		return err
+	}
+	policy := settings.SQLStartupPolicy
+	if len(policy) == 0 {
+		policy = startupContinue
+	}
+	if policy != startupFail && policy != startupContinue {
+		return errors.Errorf("unknown sql startup policy %s", policy)
+	}
+	ds.pingTimeout, err = iface.ParseDuration(settings.SQLPingTimeout, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	lifetime, err := iface.ParseDuration(settings.SQLConnMaxLifetime, 0)
+	if err != nil {
+		return err
+	}
+	flushInterval, err := iface.ParseDuration(settings.SQLFlushInterval, time.Second)
+	if err != nil {
+		return err
+	}
+	ds.batchMode = settings.SQLBatchMode
+	if len(ds.batchMode) == 0 {
+		ds.batchMode = batchInsert
+	}
+	if ds.batchMode != batchInsert && ds.batchMode != batchCopy {
+		return errors.Errorf("unknown sql batch mode %s", ds.batchMode)
+	}
+	if ds.batchMode == batchCopy && ds.dialect != postgresDialect {
+		return errors.Errorf("sql batch mode %s is supported by postgres only", ds.batchMode)
+	}
+	ds.table = settings.SQLTable
+	if len(ds.table) == 0 {
+		ds.table = defaultTable
+	}
+	ds.rawLayout = settings.SQLayout
+	if len(ds.rawLayout) == 0 {
+		ds.rawLayout = fmt.Sprintf(defaultLayout, ds.table)
+		if settings.SQLAutoMigrate {
+			ds.rawLayout = fmt.Sprintf(migratedLayout, ds.table)
+		}
+	}
+	ds.layout, ds.fields, err = ds.dialect.bindNamed(ds.rawLayout, recordFields)
+	if err != nil {
+		return errors.Wrap(err, "error parsing sql layout")
+	}
+	if len(ds.fields) == 0 {
+		ds.fields, ds.positional = legacyFields, true
+	}
+	if ds.batchMode == batchCopy {
+		var ok bool
+		if ds.copyTable, ds.copyColumns, ds.copyFields, ok = insertColumns(ds.rawLayout, recordFields); !ok {
+			return errors.Errorf("sql batch mode %s needs a layout of the form INSERT INTO table(columns) VALUES (:placeholders), got %s", ds.batchMode, ds.rawLayout)
+		}
+	}
 	ds.db, err = sql.Open(settings.SQLDriver, settings.SQLConnectionString)
-	ds.layout = settings.SQLayout
-	if len(ds.layout) == 0 {
-		ds.layout = defaultLayout
+	if err != nil {
+		return errors.Wrapf(err, "error opening database %s", settings.SQLDriver)
+	}
+	defer func() {
+		// don't leak the database (and the batcher) of a saver that failed to be configured
+		if err != nil {
+			ds.Close()
+		}
+	}()
+	ds.db.SetMaxOpenConns(settings.SQLMaxOpenConns)
+	if settings.SQLMaxIdleConns > 0 {
+		ds.db.SetMaxIdleConns(settings.SQLMaxIdleConns)
+	}
+	ds.db.SetConnMaxLifetime(lifetime)
+	ready := true
+	if err = ds.verify(ds.table, settings.SQLAutoMigrate); err != nil {
+		if policy == startupFail {
+			return err
+		}
+		ready = false
+		log.Printf("DatabaseSaver_Configure: database not ready, continuing as configured: %+v", err)
+	}
+	if settings.SQLBatchSize > 1 {
+		ds.batcher = newBatcher("DatabaseSaver "+ds.route, settings.SQLBatchSize, flushInterval, ds.saveBatch)
+	}
+	retention := &tableRetention{db: ds.db, dialect: ds.dialect, table: ds.table, timestamp: "ts", body: "payload"}
+	// records of other routes can only be told apart if the route is stored
+	for _, field := range ds.fields {
+		if field == "route" {
+			retention.route, ds.routeColumn = ds.route, true
+		}
+	}
+	if ds.janitor, err = newJanitor("DatabaseSaver "+ds.route, settings, retention.purge); err != nil {
+		return err
+	}
+	ds.tableKey = settings.SQLDriver + " " + settings.SQLConnectionString + " " + ds.table
+	if err = ds.claimTable(); err != nil || ds.janitor == nil {
+		return err
 	}
-	return
+	if ready {
+		if err = retention.check(); err != nil {
+			return err
+		}
+	}
+	ds.janitor.start()
+	return nil
 }
 
-// Save stored data into the database.
+// Save stored data into the database. If batching is enabled the data is only buffered and stored once the batch is flushed.
 func (ds *DatabaseSaver) Save(payload *iface.PayloadRecord) error {
-	_, err := ds.db.Exec(ds.layout, payload.Timestamp, payload.Remote, payload.Meta, payload.Payload)
+	if ds.batcher != nil {
+		return ds.batcher.Add(payload)
+	}
+	args, err := ds.arguments(ds.fields, payload)
+	if err != nil {
+		return err
+	}
+	_, err = ds.db.Exec(ds.layout, args...)
 	return errors.Wrap(err, "error saving payload")
 }
+
+// Close stops the retention janitor, flushes buffered records and closes the database.
+func (ds *DatabaseSaver) Close() error {
+	ds.janitor.Close()
+	ds.releaseTable()
+	if ds.batcher != nil {
+		if err := ds.batcher.Close(); err != nil {
+			log.Printf("DatabaseSaver_Close: error flushing batch %+v", err)
+		}
+	}
+	return ds.db.Close()
+}
+
+// saveBatch stores records using a single statement (or COPY) in a transaction.
+func (ds *DatabaseSaver) saveBatch(records []*iface.PayloadRecord) error {
+	if ds.debug {
+		log.Printf("DatabaseSaver_saveBatch: saving %d records", len(records))
+	}
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error starting batch")
+	}
+	if err = ds.execBatch(tx, records); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error saving batch of %d records", len(records))
+	}
+	return errors.Wrap(tx.Commit(), "error committing batch")
+}
+
+func (ds *DatabaseSaver) execBatch(tx *sql.Tx, records []*iface.PayloadRecord) error {
+	if ds.batchMode == batchCopy {
+		copyIn := pq.CopyIn(ds.copyTable, ds.copyColumns...)
+		if i := strings.Index(ds.copyTable, "."); i >= 0 {
+			copyIn = pq.CopyInSchema(ds.copyTable[:i], ds.copyTable[i+1:], ds.copyColumns...)
+		}
+		stmt, err := tx.Prepare(copyIn)
+		if err != nil {
+			return err
+		}
+		for _, payload := range records {
+			args, err := ds.arguments(ds.copyFields, payload)
+			if err != nil {
+				return err
+			}
+			if _, err = stmt.Exec(args...); err != nil {
+				return err
+			}
+		}
+		if _, err = stmt.Exec(); err != nil {
+			return err
+		}
+		return stmt.Close()
+	}
+	if _, ok := repeatValues(ds.rawLayout, 1); ok && !ds.positional {
+		// a statement may only have so many bind variables
+		rows := ds.dialect.maxParams / len(ds.fields)
+		for len(records) > 0 {
+			chunk := records
+			if len(chunk) > rows {
+				chunk = chunk[:rows]
+			}
+			if err := ds.insertRows(tx, chunk); err != nil {
+				return err
+			}
+			records = records[len(chunk):]
+		}
+		return nil
+	}
+	// layouts that can't be turned into a multi-row insert are executed row by row
+	for _, payload := range records {
+		args, err := ds.arguments(ds.fields, payload)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(ds.layout, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertRows stores records using a single multi-row insert.
+func (ds *DatabaseSaver) insertRows(tx *sql.Tx, records []*iface.PayloadRecord) error {
+	layout, _ := repeatValues(ds.rawLayout, len(records))
+	query, fields, err := ds.dialect.bindNamed(layout, recordFields)
+	if err != nil {
+		return err
+	}
+	args := make([]interface{}, 0, len(fields))
+	for i, payload := range records {
+		recordArgs, err := ds.arguments(fields[i*len(ds.fields):(i+1)*len(ds.fields)], payload)
+		if err != nil {
+			return err
+		}
+		args = append(args, recordArgs...)
+	}
+	_, err = tx.Exec(query, args...)
+	return err
+}
+
+// Health pings the database, it fails too while records of a failed batch wait to be stored.
+func (ds *DatabaseSaver) Health() error {
+	ctx, cancel := context.WithTimeout(context.Background(), ds.pingTimeout)
+	defer cancel()
+	if err := ds.db.PingContext(ctx); err != nil {
+		return errors.Wrap(err, "error pinging database")
+	}
+	if ds.batcher != nil {
+		return ds.batcher.Health()
+	}
+	return nil
+}
+
+// verify checks the database is reachable, optionally creates the table and makes sure the layout is accepted by the database.
+func (ds *DatabaseSaver) verify(table string, migrate bool) error {
+	if err := ds.Health(); err != nil {
+		return err
+	}
+	if migrate {
+		if err := ds.migrate(table); err != nil {
+			return err
+		}
+	}
+	stmt, err := ds.db.Prepare(ds.layout)
+	if err != nil {
+		return errors.Wrapf(err, "error preparing sql layout %s", ds.layout)
+	}
+	return stmt.Close()
+}
+
+// migrate creates the table if it doesn't exist yet.
+func (ds *DatabaseSaver) migrate(table string) error {
+	if ds.debug {
+		log.Printf("DatabaseSaver_migrate: creating %s table %s", ds.dialect.name, table)
+	}
+	if _, err := ds.db.Exec(fmt.Sprintf(ds.dialect.schema, table)); err != nil {
+		return errors.Wrapf(err, "error creating table %s", table)
+	}
+	if len(ds.dialect.index) == 0 {
+		return nil
+	}
+	_, err := ds.db.Exec(fmt.Sprintf(ds.dialect.index, strings.ReplaceAll(table, ".", "_"), table))
+	return errors.Wrapf(err, "error creating index of table %s", table)
+}
+
+// arguments returns values for the given fields, meta is serialized as JSON.
+func (ds *DatabaseSaver) arguments(fields []string, payload *iface.PayloadRecord) ([]interface{}, error) {
+	args := make([]interface{}, len(fields))
+	for i, field := range fields {
+		switch field {
+		case "route":
+			args[i] = ds.route
+		case "timestamp":
+			args[i] = payload.Timestamp
+		case "remote":
+			args[i] = payload.Remote
+		case "method":
+			args[i] = payload.Method
+		case "meta":
+			meta, err := json.Marshal(payload.Meta)
+			if err != nil {
+				return nil, errors.Wrap(err, "error encoding meta")
+			}
+			args[i] = string(meta)
+		case "payload":
+			args[i] = payload.Payload
+			if ds.sealer != nil {
+				sealed, err := ds.sealer.Seal([]byte(payload.Payload))
+				if err != nil {
+					return nil, errors.Wrap(err, "error sealing payload")
+				}
+				args[i] = sealed
+			}
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,165 @@
+package saver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindNamed(t *testing.T) {
+	query, names, err := postgresDialect.bindNamed("INSERT INTO t(a, b, c) VALUES (:payload, ':remote', :meta::jsonb)", recordFields)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO t(a, b, c) VALUES ($1, ':remote', $2::jsonb)", query)
+	assert.Equal(t, []string{"payload", "meta"}, names)
+	query, names, err = mysqlDialect.bindNamed("INSERT INTO t(a, b) VALUES (:route, :timestamp)", recordFields)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO t(a, b) VALUES (?, ?)", query)
+	assert.Equal(t, []string{"route", "timestamp"}, names)
+	_, _, err = mysqlDialect.bindNamed("INSERT INTO t(a) VALUES (:unknown)", recordFields)
+	assert.Error(t, err)
+}
+
+func TestDatabaseSaver_AutoMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ds := new(DatabaseSaver)
+	err = ds.Configure(&iface.Settings{
+		URI:                 "save",
+		SQLDriver:           "sqlite",
+		SQLConnectionString: filepath.Join(dir, "test.db"),
+		SQLTable:            "captured",
+		SQLAutoMigrate:      true,
+	})
+	assert.NoError(t, err)
+	err = ds.Save(&iface.PayloadRecord{
+		Payload:   "test payload",
+		Timestamp: time.Now(),
+		Method:    "PUT",
+		Meta:      map[string][]string{"Content-Type": {"application/json"}},
+	})
+	assert.NoError(t, err)
+	var route, method, meta, payload string
+	err = ds.db.QueryRow("SELECT route, method, meta, payload FROM captured").Scan(&route, &method, &meta, &payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "save", route)
+	assert.Equal(t, "PUT", method)
+	assert.Equal(t, "test payload", payload)
+	decoded := map[string][]string{}
+	assert.NoError(t, json.Unmarshal([]byte(meta), &decoded))
+	assert.Equal(t, "application/json", decoded["Content-Type"][0])
+}
+
+func TestDatabaseSaver_UnsupportedDialect(t *testing.T) {
+	err := new(DatabaseSaver).Configure(&iface.Settings{SQLDriver: "oracle"})
+	assert.Error(t, err)
+}
+
+func TestDatabaseSaver_StartupPolicy(t *testing.T) {
+	settings := &iface.Settings{
+		SQLDriver:           "mysql",
+		SQLConnectionString: "root:root@tcp(127.0.0.1:1)/glutton",
+		SQLPingTimeout:      "1s",
+	}
+	ds := new(DatabaseSaver)
+	assert.NoError(t, ds.Configure(settings))
+	assert.Error(t, ds.Health())
+	settings.SQLStartupPolicy = "fail"
+	settings.SQLBatchSize = 10
+	failed := new(DatabaseSaver)
+	assert.Error(t, failed.Configure(settings))
+	// the database of a failed saver is closed
+	assert.EqualError(t, failed.db.Ping(), "sql: database is closed")
+	settings.SQLBatchSize = 0
+	settings.SQLStartupPolicy = "maybe"
+	assert.Error(t, new(DatabaseSaver).Configure(settings))
+}
+
+func TestRepeatValues(t *testing.T) {
+	query, ok := repeatValues("INSERT INTO t(a, b) VALUES (:payload, lower(:remote)) ON CONFLICT DO NOTHING", 2)
+	assert.True(t, ok)
+	assert.Equal(t, "INSERT INTO t(a, b) VALUES (:payload, lower(:remote)), (:payload, lower(:remote)) ON CONFLICT DO NOTHING", query)
+	_, ok = repeatValues("UPDATE t SET a = :payload", 2)
+	assert.False(t, ok)
+}
+
+func TestDatabaseSaver_Batch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ds := new(DatabaseSaver)
+	err = ds.Configure(&iface.Settings{
+		URI:                 "save",
+		SQLDriver:           "sqlite",
+		SQLConnectionString: filepath.Join(dir, "test.db"),
+		SQLAutoMigrate:      true,
+		SQLBatchSize:        3,
+		SQLFlushInterval:    "1h",
+	})
+	assert.NoError(t, err)
+	count := func() (n int) {
+		assert.NoError(t, ds.db.QueryRow("SELECT count(*) FROM payload").Scan(&n))
+		return
+	}
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, ds.Save(&iface.PayloadRecord{Payload: "batched", Timestamp: time.Now()}))
+	}
+	assert.Equal(t, 0, count())
+	assert.NoError(t, ds.Save(&iface.PayloadRecord{Payload: "batched", Timestamp: time.Now()}))
+	assert.Equal(t, 3, count())
+	assert.NoError(t, ds.Save(&iface.PayloadRecord{Payload: "batched", Timestamp: time.Now()}))
+	assert.NoError(t, ds.batcher.Close())
+	assert.Equal(t, 4, count())
+}
+
+func TestDatabaseSaver_CopyRequiresPostgres(t *testing.T) {
+	err := new(DatabaseSaver).Configure(&iface.Settings{SQLDriver: "sqlite", SQLBatchMode: "copy"})
+	assert.Error(t, err)
+}
+
+func TestInsertColumns(t *testing.T) {
+	table, columns, fields, ok := insertColumns(`INSERT INTO audit.captured(ts, "body", meta) VALUES (:timestamp, :payload, :meta::jsonb)`, recordFields)
+	assert.True(t, ok)
+	assert.Equal(t, "audit.captured", table)
+	assert.Equal(t, []string{"ts", "body", "meta"}, columns)
+	assert.Equal(t, []string{"timestamp", "payload", "meta"}, fields)
+	_, _, _, ok = insertColumns("INSERT INTO t(a, b) VALUES (:payload, lower(:remote))", recordFields)
+	assert.False(t, ok)
+	_, _, _, ok = insertColumns("INSERT INTO t(a, b) VALUES ($1, $2)", recordFields)
+	assert.False(t, ok)
+	err := new(DatabaseSaver).Configure(&iface.Settings{SQLDriver: "postgres", SQLBatchMode: "copy", SQLayout: "INSERT INTO t(a) VALUES ($1)"})
+	assert.Error(t, err)
+}
+
+func TestDatabaseSaver_LargeBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ds := new(DatabaseSaver)
+	err = ds.Configure(&iface.Settings{
+		URI:                 "save",
+		SQLDriver:           "sqlite",
+		SQLConnectionString: filepath.Join(dir, "test.db"),
+		SQLAutoMigrate:      true,
+		SQLBatchSize:        2,
+		SQLFlushInterval:    "1h",
+	})
+	assert.NoError(t, err)
+	// more rows than fit in a single statement
+	records := make([]*iface.PayloadRecord, sqliteDialect.maxParams/6+10)
+	for i := range records {
+		records[i] = &iface.PayloadRecord{Payload: "batched", Timestamp: time.Now()}
+	}
+	assert.NoError(t, ds.saveBatch(records))
+	var n int
+	assert.NoError(t, ds.db.QueryRow("SELECT count(*) FROM payload").Scan(&n))
+	assert.Equal(t, len(records), n)
+	assert.NoError(t, ds.Health())
+	assert.NoError(t, ds.Close())
+}
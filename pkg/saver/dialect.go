@@ -0,0 +1,196 @@
+package saver
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// dialect describes the differences between supported SQL databases.
+type dialect struct {
+	name string
+	// schema is the CREATE TABLE statement, %s is replaced by the table name.
+	schema string
+	// index is the CREATE INDEX statement of route and ts used by retention, %[1]s is replaced by the index name prefix and %[2]s by the table name. Empty if the schema creates the index.
+	index string
+	// placeholder returns the bind variable for n-th (1 based) argument.
+	placeholder func(n int) string
+	// byteLength is the expression measuring a column in bytes, %s is replaced by the column name.
+	byteLength string
+	// maxParams is the number of bind variables a single statement may have.
+	maxParams int
+}
+
+// simpleInsert matches `INSERT INTO table(columns) VALUES (values)`.
+var simpleInsert = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+([\w."]+)\s*\(([^)]*)\)\s*VALUES\s*\(([^()]*)\)\s*;?\s*$`)
+
+// placeholderValue matches a value that is a named placeholder only, optionally cast (`:meta::jsonb`).
+var placeholderValue = regexp.MustCompile(`^:([A-Za-z_]+)(::\w+)?$`)
+
+var (
+	postgresDialect = &dialect{
+		name: "postgres",
+		schema: `CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			route TEXT NOT NULL,
+			ts TIMESTAMPTZ NOT NULL,
+			remote TEXT,
+			method TEXT,
+			meta JSONB,
+			payload TEXT
+		)`,
+		index:       `CREATE INDEX IF NOT EXISTS %[1]s_route_ts ON %[2]s(route, ts)`,
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		byteLength:  "octet_length(%s)",
+		maxParams:   65535,
+	}
+	mysqlDialect = &dialect{
+		name: "mysql",
+		schema: `CREATE TABLE IF NOT EXISTS %s (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			route VARCHAR(255) NOT NULL,
+			ts DATETIME(6) NOT NULL,
+			remote VARCHAR(255),
+			method VARCHAR(16),
+			meta JSON,
+			payload LONGTEXT,
+			INDEX route_ts (route, ts)
+		)`,
+		placeholder: func(int) string { return "?" },
+		byteLength:  "length(%s)",
+		maxParams:   65535,
+	}
+	sqliteDialect = &dialect{
+		name: "sqlite",
+		schema: `CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			route TEXT NOT NULL,
+			ts DATETIME NOT NULL,
+			remote TEXT,
+			method TEXT,
+			meta TEXT,
+			payload TEXT
+		)`,
+		index:       `CREATE INDEX IF NOT EXISTS %[1]s_route_ts ON %[2]s(route, ts)`,
+		placeholder: func(int) string { return "?" },
+		byteLength:  "length(CAST(%s AS BLOB))",
+		maxParams:   32766,
+	}
+)
+
+// dialects maps both dialect and driver names to dialects.
+var dialects = map[string]*dialect{
+	"postgres": postgresDialect,
+	"pgx":      postgresDialect,
+	"mysql":    mysqlDialect,
+	"sqlite":   sqliteDialect,
+	"sqlite3":  sqliteDialect,
+}
+
+// dialectFor picks the dialect by its explicit name or, if empty, by the driver name.
+func dialectFor(name, driver string) (*dialect, error) {
+	if len(name) == 0 {
+		name = driver
+	}
+	if d, found := dialects[strings.ToLower(name)]; found {
+		return d, nil
+	}
+	return nil, errors.Errorf("unsupported sql dialect %s", name)
+}
+
+// bindNamed replaces named placeholders (e.g. `:payload`) in query with the dialect's positional ones. It returns the rewritten query and the names in the order of their positions.
+// Postgres style casts (`::jsonb`) and quoted literals are left intact.
+func (d *dialect) bindNamed(query string, known map[string]bool) (string, []string, error) {
+	var (
+		builder = new(strings.Builder)
+		names   []string
+		quoted  bool
+	)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case quoted || c != ':':
+		case i+1 < len(query) && query[i+1] == ':':
+			builder.WriteString("::")
+			i++
+			continue
+		default:
+			j := i + 1
+			for j < len(query) && (query[j] == '_' || 'a' <= query[j] && query[j] <= 'z' || 'A' <= query[j] && query[j] <= 'Z') {
+				j++
+			}
+			if j == i+1 {
+				break
+			}
+			name := query[i+1 : j]
+			if !known[name] {
+				return "", nil, errors.Errorf("unknown placeholder :%s in %s", name, query)
+			}
+			names = append(names, name)
+			builder.WriteString(d.placeholder(len(names)))
+			i = j - 1
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String(), names, nil
+}
+
+// repeatValues turns a single row insert into an insert of n rows by repeating the tuple following VALUES. It returns false if no such tuple is found.
+func repeatValues(query string, n int) (string, bool) {
+	start := strings.LastIndex(strings.ToUpper(query), "VALUES")
+	if start < 0 {
+		return "", false
+	}
+	open := strings.IndexByte(query[start:], '(')
+	if open < 0 {
+		return "", false
+	}
+	open += start
+	depth, quoted := 0, false
+	for i := open; i < len(query); i++ {
+		switch {
+		case query[i] == '\'':
+			quoted = !quoted
+		case quoted:
+		case query[i] == '(':
+			depth++
+		case query[i] == ')':
+			depth--
+			if depth == 0 {
+				tuples := make([]string, n)
+				for j := range tuples {
+					tuples[j] = query[open : i+1]
+				}
+				return query[:open] + strings.Join(tuples, ", ") + query[i+1:], true
+			}
+		}
+	}
+	return "", false
+}
+
+// insertColumns parses a layout inserting named placeholders only into a table. It returns the table, its columns and the fields filling them, false if the layout is anything else.
+func insertColumns(layout string, known map[string]bool) (string, []string, []string, bool) {
+	match := simpleInsert.FindStringSubmatch(layout)
+	if match == nil {
+		return "", nil, nil, false
+	}
+	columns, values := strings.Split(match[2], ","), strings.Split(match[3], ",")
+	if len(columns) != len(values) {
+		return "", nil, nil, false
+	}
+	fields := make([]string, len(values))
+	for i := range values {
+		columns[i] = strings.Trim(strings.TrimSpace(columns[i]), `"`)
+		value := placeholderValue.FindStringSubmatch(strings.TrimSpace(values[i]))
+		if value == nil || !known[value[1]] || len(columns[i]) == 0 {
+			return "", nil, nil, false
+		}
+		fields[i] = value[1]
+	}
+	return match[1], columns, fields, true
+}
@@ -0,0 +1,232 @@
+package saver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	defaultElasticsearchURL   = "http://localhost:9200"
+	defaultElasticsearchIndex = `glutton-{{.Route}}-{{.Timestamp.Format "2006.01.02"}}`
+)
+
+// bulkResponse is the part of the `_bulk` response needed to find failed items.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulkItem is a single document of a bulk request.
+type bulkItem struct {
+	action   []byte
+	document []byte
+	record   *iface.PayloadRecord
+}
+
+// invalidIndexChars are not allowed in index names.
+const invalidIndexChars = `\/*?"<>| ,#:`
+
+// ElasticsearchSaver indexes payload in Elasticsearch (or OpenSearch) using the `_bulk` API. Records are buffered and indexed in batches.
+type ElasticsearchSaver struct {
+	urls          []string
+	indexTemplate *template.Template
+	username      string
+	password      string
+	apiKey        string
+	retries       int
+	backoff       time.Duration
+	route         string
+	batcher       *batcher
+	client        *http.Client
+	debug         bool
+}
+
+// Configure bootstraps the ElasticsearchSaver.
+// Namely the following params are used:
+// * ElasticsearchURLs - nodes to send requests to, tried in turn on retries
+// * ElasticsearchIndex - template of the index name (lower cased, characters not allowed in index names replaced by `_`), the route is given with `/` replaced by `_`
+// * ElasticsearchUsername, ElasticsearchPassword, ElasticsearchAPIKey - basic or api key authentication
+// * ElasticsearchBatchSize, ElasticsearchFlushInterval - size of a bulk request and how long to wait for it to fill up
+// * ElasticsearchRetries, ElasticsearchRetryBackoff, ElasticsearchTimeout - retrying of rejected (429, 5xx) documents
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (e *ElasticsearchSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("ElasticsearchSaver", settings); err != nil {
+		return err
+	}
+	if e.indexTemplate, err = parseTemplate("elasticsearch index", settings.ElasticsearchIndex, defaultElasticsearchIndex); err != nil {
+		return err
+	}
+	flushInterval, err := iface.ParseDuration(settings.ElasticsearchFlushInterval, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if e.backoff, err = iface.ParseDuration(settings.ElasticsearchRetryBackoff, time.Second); err != nil {
+		return err
+	}
+	timeout, err := iface.ParseDuration(settings.ElasticsearchTimeout, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	e.urls = settings.ElasticsearchURLs
+	if len(e.urls) == 0 {
+		e.urls = []string{defaultElasticsearchURL}
+	}
+	e.username = settings.ElasticsearchUsername
+	e.password = settings.ElasticsearchPassword
+	e.apiKey = settings.ElasticsearchAPIKey
+	e.retries = settings.ElasticsearchRetries
+	e.route = settings.URI
+	e.debug = settings.Debug
+	e.client = &http.Client{Timeout: timeout}
+	e.batcher = newBatcher("ElasticsearchSaver "+e.route, settings.ElasticsearchBatchSize, flushInterval, e.saveBatch)
+	return nil
+}
+
+// Save adds the payload to the current batch, the batch is indexed once full.
+func (e *ElasticsearchSaver) Save(payload *iface.PayloadRecord) error {
+	return e.batcher.Add(payload)
+}
+
+// Health fails while documents that failed to be indexed wait for the next bulk request.
+func (e *ElasticsearchSaver) Health() error {
+	return e.batcher.Health()
+}
+
+// Close indexes the current batch.
+func (e *ElasticsearchSaver) Close() error {
+	return e.batcher.Close()
+}
+
+// saveBatch indexes records, documents rejected with a retryable status are sent again. Documents still failing after the retries are kept by the batcher for the next bulk request, documents rejected for good are not.
+func (e *ElasticsearchSaver) saveBatch(records []*iface.PayloadRecord) error {
+	items := make([]bulkItem, 0, len(records))
+	for _, payload := range records {
+		index, err := executeTemplate(e.indexTemplate, &recordData{Route: indexRoute(e.route), PayloadRecord: payload})
+		if err != nil {
+			return err
+		}
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": indexName(index)}})
+		document, err := json.Marshal(&recordData{Route: e.route, PayloadRecord: payload})
+		if err != nil {
+			return errors.Wrap(err, "error encoding record")
+		}
+		items = append(items, bulkItem{action, document, payload})
+	}
+	var rejected []string
+	backoff := e.backoff
+	for attempt := 0; ; attempt++ {
+		permanent, retryable, err := e.bulk(e.urls[attempt%len(e.urls)], items)
+		if err == nil {
+			rejected = append(rejected, permanent...)
+			items = retryable
+		}
+		if err == nil && len(items) == 0 {
+			break
+		}
+		if attempt >= e.retries {
+			if err == nil {
+				err = errors.Errorf("%d documents still rejected after %d attempts", len(items), attempt+1)
+			}
+			if len(rejected) > 0 {
+				err = errors.Wrapf(err, "%d documents rejected for good: %s", len(rejected), strings.Join(rejected, "; "))
+			}
+			retry := make([]*iface.PayloadRecord, len(items))
+			for i, item := range items {
+				retry[i] = item.record
+			}
+			return &retryError{records: retry, err: errors.Wrap(err, "error indexing payload")}
+		}
+		if e.debug {
+			log.Printf("ElasticsearchSaver_saveBatch: attempt %d failed, retrying %d documents %+v", attempt+1, len(items), err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if len(rejected) > 0 {
+		return errors.Errorf("error indexing payload, %d of %d documents rejected: %s", len(rejected), len(records), strings.Join(rejected, "; "))
+	}
+	return nil
+}
+
+// bulk sends a single bulk request. It returns reasons of documents rejected for good and documents worth retrying.
+func (e *ElasticsearchSaver) bulk(url string, items []bulkItem) ([]string, []bulkItem, error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		body.Write(item.action)
+		body.WriteByte('\n')
+		body.Write(item.document)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+"/_bulk", &body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating bulk request")
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if len(e.apiKey) > 0 {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if len(e.username) > 0 {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error sending bulk request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, nil, errors.Errorf("bulk request failed: %s %s", resp.Status, detail)
+	}
+	result := new(bulkResponse)
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, nil, errors.Wrap(err, "error decoding bulk response")
+	}
+	if !result.Errors {
+		return nil, nil, nil
+	}
+	var (
+		rejected  []string
+		retryable []bulkItem
+	)
+	for i, item := range result.Items {
+		for _, outcome := range item {
+			switch {
+			case outcome.Status < 300 || i >= len(items):
+			case outcome.Status == http.StatusTooManyRequests || outcome.Status >= 500:
+				retryable = append(retryable, items[i])
+			default:
+				rejected = append(rejected, string(outcome.Error))
+			}
+		}
+	}
+	return rejected, retryable, nil
+}
+
+// indexRoute makes the route fit for index names, `/v1/contact` becomes `v1_contact`.
+func indexRoute(route string) string {
+	return strings.ToLower(strings.Trim(strings.ReplaceAll(route, "/", "_"), "_"))
+}
+
+// indexName lower cases the index and replaces characters not allowed in index names.
+func indexName(index string) string {
+	index = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(invalidIndexChars, r) {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(index))
+	return strings.TrimLeft(index, "-_+")
+}
@@ -0,0 +1,99 @@
+package saver
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElasticsearchSaver_Save(t *testing.T) {
+	var requests [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		var lines []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		requests = append(requests, lines)
+		if len(requests) == 1 {
+			// first document is accepted, second one rejected for now
+			fmt.Fprint(w, `{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"errors":false,"items":[{"index":{"status":201}}]}`)
+	}))
+	defer server.Close()
+	e := new(ElasticsearchSaver)
+	err := e.Configure(&iface.Settings{
+		URI:                        "Save",
+		ElasticsearchURLs:          []string{server.URL},
+		ElasticsearchBatchSize:     2,
+		ElasticsearchFlushInterval: "1h",
+		ElasticsearchRetries:       2,
+		ElasticsearchRetryBackoff:  "1ms",
+	})
+	assert.NoError(t, err)
+	timestamp := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, e.Save(&iface.PayloadRecord{Payload: "first", Timestamp: timestamp}))
+	assert.Len(t, requests, 0)
+	assert.NoError(t, e.Save(&iface.PayloadRecord{Payload: "second", Timestamp: timestamp}))
+	assert.Len(t, requests, 2)
+	assert.Len(t, requests[0], 4)
+	assert.Equal(t, `{"index":{"_index":"glutton-save-2018.10.01"}}`, requests[0][0])
+	assert.Len(t, requests[1], 2)
+	assert.True(t, strings.Contains(requests[1][1], `"payload":"second"`))
+}
+
+func TestElasticsearchSaver_Rejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`)
+	}))
+	defer server.Close()
+	e := new(ElasticsearchSaver)
+	assert.NoError(t, e.Configure(&iface.Settings{ElasticsearchURLs: []string{server.URL}, ElasticsearchBatchSize: 1, ElasticsearchRetries: 3}))
+	err := e.Save(&iface.PayloadRecord{Payload: "first", Timestamp: time.Now()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mapper_parsing_exception")
+}
+
+func TestElasticsearchSaver_Retained(t *testing.T) {
+	var documents []string
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			documents = append(documents, scanner.Text())
+		}
+		fmt.Fprint(w, `{"errors":false}`)
+	}))
+	defer server.Close()
+	e := new(ElasticsearchSaver)
+	assert.NoError(t, e.Configure(&iface.Settings{URI: "/v1/Contact", ElasticsearchURLs: []string{server.URL}, ElasticsearchBatchSize: 1, ElasticsearchFlushInterval: "1h", ElasticsearchRetryBackoff: "1ms"}))
+	timestamp := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Error(t, e.Save(&iface.PayloadRecord{Payload: "first", Timestamp: timestamp}))
+	assert.Error(t, e.Health())
+	available = true
+	assert.NoError(t, e.Close())
+	assert.NoError(t, e.Health())
+	assert.Len(t, documents, 2)
+	assert.Equal(t, `{"index":{"_index":"glutton-v1_contact-2018.10.01"}}`, documents[0])
+	assert.Contains(t, documents[1], `"route":"/v1/Contact"`)
+}
+
+func TestIndexName(t *testing.T) {
+	assert.Equal(t, "glutton-a_b_c", indexName("Glutton-a/b c"))
+	assert.Equal(t, "save", indexName("_save"))
+	assert.Equal(t, "v1_contact", indexRoute("/v1/Contact/"))
+}
@@ -0,0 +1,163 @@
+package saver
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+// maxUpstreamBody limits how much of the upstream response is kept on the record.
+const maxUpstreamBody = 64 * 1024
+
+// hopByHopHeaders are never forwarded.
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length", "Host"}
+
+// HTTPForwardSaver re-sends payload to an upstream URL and records the upstream response on the payload. Chain it with another saver (e.g. `saver: HTTPForwardSaver,SimpleFileSystemSaver`) to keep a copy of every forwarded payload.
+type HTTPForwardSaver struct {
+	url      string
+	method   string
+	allow    map[string]bool
+	deny     map[string]bool
+	retries  int
+	backoff  time.Duration
+	deadline time.Duration
+	client   *http.Client
+	debug    bool
+}
+
+// Configure bootstraps the HTTPForwardSaver.
+// Namely the following params are used:
+// * ForwardURL - where to send payload
+// * ForwardMethod - method to use, the original one if empty
+// * ForwardHeaderAllow, ForwardHeaderDeny - headers to forward (all if the allow list is empty) and not to forward
+// * ForwardTimeout, ForwardRetries, ForwardRetryBackoff - per attempt timeout, number of retries and the (doubling) pause between them
+// * ForwardDeadline - how long forwarding may take in total, attempts included, as the request waits for it
+func (f *HTTPForwardSaver) Configure(settings *iface.Settings) (err error) {
+	if len(settings.ForwardURL) == 0 {
+		return errors.New("forward url not configured")
+	}
+	timeout, err := iface.ParseDuration(settings.ForwardTimeout, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if f.backoff, err = iface.ParseDuration(settings.ForwardRetryBackoff, time.Second); err != nil {
+		return err
+	}
+	if f.deadline, err = iface.ParseDuration(settings.ForwardDeadline, 15*time.Second); err != nil {
+		return err
+	}
+	f.url = settings.ForwardURL
+	f.method = settings.ForwardMethod
+	f.allow = headerSet(settings.ForwardHeaderAllow)
+	f.deny = headerSet(settings.ForwardHeaderDeny, hopByHopHeaders)
+	f.retries = settings.ForwardRetries
+	f.client = &http.Client{Timeout: timeout}
+	f.debug = settings.Debug
+	return nil
+}
+
+// Save forwards payload, retrying on network errors and 5xx/429 responses until the deadline. The last attempt's outcome is stored in payload.Upstream.
+func (f *HTTPForwardSaver) Save(payload *iface.PayloadRecord) error {
+	payload.Upstream = &iface.UpstreamResponse{URL: f.url}
+	ctx := context.Background()
+	if f.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.deadline)
+		defer cancel()
+	}
+	backoff := f.backoff
+	for attempt := 0; attempt <= f.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				payload.Upstream.Error += " (deadline exceeded)"
+				return errors.Errorf("error forwarding payload to %s: %s", f.url, payload.Upstream.Error)
+			}
+			backoff *= 2
+		}
+		payload.Upstream.Attempts = attempt + 1
+		retry, err := f.forward(ctx, payload)
+		if err == nil {
+			return nil
+		}
+		payload.Upstream.Error = err.Error()
+		if f.debug {
+			log.Printf("HTTPForwardSaver_Save: attempt %d failed %+v", attempt+1, err)
+		}
+		if !retry {
+			break
+		}
+	}
+	return errors.Errorf("error forwarding payload to %s: %s", f.url, payload.Upstream.Error)
+}
+
+// forward makes a single attempt, it returns whether a failure is worth retrying.
+func (f *HTTPForwardSaver) forward(ctx context.Context, payload *iface.PayloadRecord) (bool, error) {
+	method := f.method
+	if len(method) == 0 {
+		method = payload.Method
+	}
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+	content := payload.Payload
+	if payload.Duplicate != nil {
+		// duplicates are forwarded as they came, only their stored copy is a reference
+		content = payload.Duplicate.Body
+	}
+	req, err := http.NewRequestWithContext(ctx, method, f.url, strings.NewReader(content))
+	if err != nil {
+		return false, errors.Wrap(err, "error creating request")
+	}
+	for name, values := range payload.Meta {
+		name = http.CanonicalHeaderKey(name)
+		if f.deny[name] || len(f.allow) > 0 && !f.allow[name] {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	started := time.Now()
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUpstreamBody))
+	payload.Upstream.Duration = time.Since(started)
+	payload.Upstream.Status = resp.StatusCode
+	payload.Upstream.Header = resp.Header
+	payload.Upstream.Body = string(body)
+	payload.Upstream.Error = ""
+	if err != nil {
+		return true, errors.Wrap(err, "error reading response")
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return true, errors.Errorf("upstream replied %s", resp.Status)
+	}
+	if resp.StatusCode >= 400 {
+		return false, errors.Errorf("upstream replied %s", resp.Status)
+	}
+	return false, nil
+}
+
+// headerSet makes a set of canonical header names from the given lists.
+func headerSet(lists ...[]string) map[string]bool {
+	set := map[string]bool{}
+	for _, names := range lists {
+		for _, name := range names {
+			set[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return set
+}
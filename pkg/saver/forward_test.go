@@ -0,0 +1,122 @@
+package saver
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPForwardSaver_Save(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "forwarded payload", string(body))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Empty(t, r.Header.Get("Cookie"))
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("accepted"))
+	}))
+	defer server.Close()
+	f := new(HTTPForwardSaver)
+	err := f.Configure(&iface.Settings{ForwardURL: server.URL, ForwardRetries: 2, ForwardRetryBackoff: "1ms", ForwardHeaderDeny: []string{"cookie"}})
+	assert.NoError(t, err)
+	payload := &iface.PayloadRecord{
+		Payload: "forwarded payload",
+		Method:  http.MethodPut,
+		Meta:    map[string][]string{"Content-Type": {"application/json"}, "Cookie": {"session=1"}},
+	}
+	assert.NoError(t, f.Save(payload))
+	assert.Equal(t, 2, payload.Upstream.Attempts)
+	assert.Equal(t, http.StatusAccepted, payload.Upstream.Status)
+	assert.Equal(t, "accepted", payload.Upstream.Body)
+	assert.Equal(t, "yes", payload.Upstream.Header["X-Upstream"][0])
+}
+
+func TestHTTPForwardSaver_Duplicate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "forwarded payload", string(body))
+	}))
+	defer server.Close()
+	f := new(HTTPForwardSaver)
+	assert.NoError(t, f.Configure(&iface.Settings{ForwardURL: server.URL}))
+	payload := &iface.PayloadRecord{Duplicate: &iface.Duplicate{Hash: "sha256:abc", Body: "forwarded payload"}}
+	assert.NoError(t, f.Save(payload))
+	assert.Equal(t, http.StatusOK, payload.Upstream.Status)
+}
+
+func TestHTTPForwardSaver_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	f := new(HTTPForwardSaver)
+	assert.NoError(t, f.Configure(&iface.Settings{ForwardURL: server.URL, ForwardRetries: 3, ForwardRetryBackoff: "1ms"}))
+	payload := &iface.PayloadRecord{Payload: "forwarded payload", Timestamp: time.Now()}
+	assert.Error(t, f.Save(payload))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, http.StatusBadRequest, payload.Upstream.Status)
+	assert.Contains(t, payload.String(), "400")
+}
+
+func TestHTTPForwardSaver_Deadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	f := new(HTTPForwardSaver)
+	assert.NoError(t, f.Configure(&iface.Settings{ForwardURL: server.URL, ForwardRetries: 10, ForwardRetryBackoff: "20ms", ForwardDeadline: "100ms"}))
+	payload := &iface.PayloadRecord{Payload: "forwarded payload"}
+	start := time.Now()
+	err := f.Save(payload)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "deadline")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Less(t, payload.Upstream.Attempts, 11)
+}
+
+type testSaver struct {
+	err   error
+	saved int
+}
+
+func (t *testSaver) Configure(*iface.Settings) error {
+	return nil
+}
+
+func (t *testSaver) Save(*iface.PayloadRecord) error {
+	t.saved++
+	return t.err
+}
+
+func TestMultiSaver_Save(t *testing.T) {
+	failing, working := &testSaver{err: errors.New("upstream down")}, &testSaver{}
+	m := &MultiSaver{Savers: []iface.PayloadSaver{failing, working}}
+	err := m.Save(&iface.PayloadRecord{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "upstream down")
+	assert.False(t, err.(iface.PartialSaveError).Saved())
+	assert.Equal(t, 1, failing.saved)
+	assert.Equal(t, 1, working.saved)
+	// only the primary saver decides whether the payload was saved
+	m = &MultiSaver{Savers: []iface.PayloadSaver{working, failing}}
+	err = m.Save(&iface.PayloadRecord{})
+	assert.Error(t, err)
+	assert.True(t, err.(iface.PartialSaveError).Saved())
+	assert.NoError(t, (&MultiSaver{Savers: []iface.PayloadSaver{working}}).Save(&iface.PayloadRecord{}))
+}
@@ -0,0 +1,272 @@
+package saver
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	defaultHARPath = "glutton.har"
+	// harHeader and harTrailer surround the entries of a HAR file written by glutton.
+	harHeader  = "{\"log\":{\"version\":\"1.2\",\"creator\":{\"name\":\"glutton\",\"version\":\"1\"},\"entries\":[\n"
+	harTrailer = "\n]}}\n"
+)
+
+// harNameValue is a header, query parameter or posted parameter of a HAR entry.
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Comment     string         `json:"comment,omitempty"`
+}
+
+// harTimings are in milliseconds. Receive is the time glutton took to read the request, wait the time the upstream took to answer.
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// newHAREntry describes the payload as a HAR 1.2 entry. The response is that of the upstream the payload was forwarded to, if any, otherwise it is empty (status 0). Timings glutton doesn't know (e.g. of records kept before they were measured) are zero.
+func newHAREntry(payload *iface.PayloadRecord) *harEntry {
+	entry := &harEntry{
+		StartedDateTime: payload.Timestamp.Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      payload.Method,
+			URL:         payload.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(payload.Meta),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(payload.Payload),
+		},
+		Response: harResponse{
+			Cookies:  []harNameValue{},
+			Headers:  []harNameValue{},
+			Content:  harContent{MimeType: "x-unknown"},
+			BodySize: -1,
+		},
+	}
+	entry.Timings.Receive = milliseconds(payload.Received)
+	if len(entry.Request.Method) == 0 {
+		entry.Request.Method = "POST"
+	}
+	if u, err := url.Parse(payload.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{name, value})
+			}
+		}
+		sort.Slice(entry.Request.QueryString, func(i, j int) bool { return entry.Request.QueryString[i].Name < entry.Request.QueryString[j].Name })
+	}
+	if len(payload.Payload) > 0 {
+		entry.Request.PostData = &harPostData{MimeType: firstValue(payload.Meta, "Content-Type"), Text: payload.Payload}
+	}
+	if host, _, err := net.SplitHostPort(payload.Remote); err == nil {
+		entry.Comment = "remote " + host
+	}
+	if upstream := payload.Upstream; upstream != nil {
+		entry.Response = harResponse{
+			Status:      upstream.Status,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(upstream.Header),
+			Content:     harContent{Size: len(upstream.Body), MimeType: firstValue(upstream.Header, "Content-Type"), Text: upstream.Body},
+			RedirectURL: firstValue(upstream.Header, "Location"),
+			HeadersSize: -1,
+			BodySize:    len(upstream.Body),
+			Comment:     "forwarded to " + upstream.URL + " " + upstream.Error,
+		}
+		entry.Timings.Wait = milliseconds(upstream.Duration)
+	}
+	entry.Time = entry.Timings.Send + entry.Timings.Wait + entry.Timings.Receive
+	return entry
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func harHeaders(header map[string][]string) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+func firstValue(header map[string][]string, name string) string {
+	if values := header[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// HARWriter streams payload as entries of a single HAR document, the document is complete once closed.
+type HARWriter struct {
+	w       io.Writer
+	entries int
+}
+
+// NewHARWriter creates a HARWriter and starts the document.
+func NewHARWriter(w io.Writer) (*HARWriter, error) {
+	_, err := io.WriteString(w, harHeader)
+	return &HARWriter{w: w}, errors.Wrap(err, "error writing har")
+}
+
+// WriteEntry writes the payload as an entry.
+func (h *HARWriter) WriteEntry(payload *iface.PayloadRecord) error {
+	entry, err := json.Marshal(newHAREntry(payload))
+	if err != nil {
+		return errors.Wrap(err, "error encoding har entry")
+	}
+	if h.entries > 0 {
+		entry = append([]byte(",\n"), entry...)
+	}
+	if _, err = h.w.Write(entry); err != nil {
+		return errors.Wrap(err, "error writing har")
+	}
+	h.entries++
+	return nil
+}
+
+// Close ends the document.
+func (h *HARWriter) Close() error {
+	_, err := io.WriteString(h.w, harTrailer)
+	return errors.Wrap(err, "error writing har")
+}
+
+// HARSaver keeps payload as entries of a HAR file. The file is a complete HAR document after every save, entries are inserted before its end.
+type HARSaver struct {
+	path    string
+	mutex   sync.Mutex
+	file    *os.File
+	entries bool
+	debug   bool
+}
+
+// Configure opens (creates) the HAR file, an existing file must have been written by glutton.
+// Namely the following params are used:
+// * HARPath - file to keep entries in
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (s *HARSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("HARSaver", settings); err != nil {
+		return err
+	}
+	s.path = settings.HARPath
+	if len(s.path) == 0 {
+		s.path = defaultHARPath
+	}
+	s.debug = settings.Debug
+	if s.file, err = os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		return errors.Wrapf(err, "error opening har file %s", s.path)
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "error opening har file %s", s.path)
+	}
+	if info.Size() == 0 {
+		_, err = s.file.WriteString(harHeader + harTrailer)
+		return errors.Wrapf(err, "error initializing har file %s", s.path)
+	}
+	if info.Size() < int64(len(harHeader)+len(harTrailer)) {
+		return errors.Errorf("har file %s not written by glutton", s.path)
+	}
+	trailer := make([]byte, len(harTrailer))
+	if _, err = s.file.ReadAt(trailer, info.Size()-int64(len(trailer))); err != nil {
+		return errors.Wrapf(err, "error reading har file %s", s.path)
+	}
+	if string(trailer) != harTrailer {
+		return errors.Errorf("har file %s not written by glutton", s.path)
+	}
+	s.entries = info.Size() > int64(len(harHeader)+len(harTrailer))
+	return nil
+}
+
+// Save inserts the payload as the last entry.
+func (s *HARSaver) Save(payload *iface.PayloadRecord) error {
+	entry, err := json.Marshal(newHAREntry(payload))
+	if err != nil {
+		return errors.Wrap(err, "error encoding har entry")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.entries {
+		entry = append([]byte(",\n"), entry...)
+	}
+	end, err := s.file.Seek(-int64(len(harTrailer)), io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "error seeking in har file %s", s.path)
+	}
+	if _, err = s.file.WriteAt(append(entry, harTrailer...), end); err != nil {
+		return errors.Wrapf(err, "error saving payload to %s", s.path)
+	}
+	s.entries = true
+	return nil
+}
+
+// Close closes the HAR file.
+func (s *HARSaver) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
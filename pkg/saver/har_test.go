@@ -0,0 +1,62 @@
+package saver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARSaver_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.har")
+	payload := &iface.PayloadRecord{
+		Payload:   `{"a":1}`,
+		Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Method:    "PUT",
+		URL:       "http://localhost/v1/glutton/save?x=y",
+		Meta:      map[string][]string{"Content-Type": {"application/json"}},
+	}
+	s := new(HARSaver)
+	assert.NoError(t, s.Configure(&iface.Settings{HARPath: path}))
+	assert.NoError(t, s.Save(payload))
+	assert.NoError(t, s.Save(payload))
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Configure(&iface.Settings{HARPath: path}))
+	payload.Received = 2 * time.Millisecond
+	payload.Upstream = &iface.UpstreamResponse{URL: "http://upstream", Status: 201, Body: "created", Duration: 10 * time.Millisecond}
+	assert.NoError(t, s.Save(payload))
+	assert.NoError(t, s.Close())
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	var har struct {
+		Log struct {
+			Version string
+			Entries []harEntry
+		}
+	}
+	assert.NoError(t, json.Unmarshal(content, &har))
+	assert.Equal(t, "1.2", har.Log.Version)
+	assert.Len(t, har.Log.Entries, 3)
+	entry := har.Log.Entries[0]
+	assert.Equal(t, "2020-01-02T03:04:05Z", entry.StartedDateTime)
+	assert.Equal(t, "PUT", entry.Request.Method)
+	assert.Equal(t, payload.URL, entry.Request.URL)
+	assert.Equal(t, []harNameValue{{"x", "y"}}, entry.Request.QueryString)
+	assert.Equal(t, []harNameValue{{"Content-Type", "application/json"}}, entry.Request.Headers)
+	assert.Equal(t, &harPostData{MimeType: "application/json", Text: `{"a":1}`}, entry.Request.PostData)
+	assert.Equal(t, 201, har.Log.Entries[2].Response.Status)
+	assert.Equal(t, "created", har.Log.Entries[2].Response.Content.Text)
+	assert.Equal(t, harTimings{Wait: 10, Receive: 2}, har.Log.Entries[2].Timings)
+	assert.Equal(t, 12.0, har.Log.Entries[2].Time)
+	// foreign files are refused
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"log":{"entries":[]}}`), 0644))
+	assert.Error(t, s.Configure(&iface.Settings{HARPath: path}))
+}
@@ -0,0 +1,112 @@
+package saver
+
+import (
+	"context"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const defaultKafkaTopic = "glutton"
+
+// kafkaAcks maps delivery guarantee settings to kafka acknowledgements.
+var kafkaAcks = map[string]kafka.RequiredAcks{
+	"none": kafka.RequireNone,
+	"one":  kafka.RequireOne,
+	"all":  kafka.RequireAll,
+}
+
+// KafkaSaver publishes payload as JSON to a Kafka topic.
+type KafkaSaver struct {
+	writer        *kafka.Writer
+	topicTemplate *template.Template
+	keyTemplate   *template.Template
+	route         string
+	timeout       time.Duration
+	debug         bool
+}
+
+// Configure bootstraps the KafkaSaver.
+// Namely the following params are used:
+// * KafkaBrokers - list of brokers to connect to
+// * KafkaTopic, KafkaKey - templates of topic and message key (records with the same key end up in the same partition)
+// * KafkaAcks - `none`, `one` or `all` (default) replicas need to acknowledge a message before it's considered saved
+// * KafkaTimeout - how long to wait for the acknowledgement
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (k *KafkaSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("KafkaSaver", settings); err != nil {
+		return err
+	}
+	if len(settings.KafkaBrokers) == 0 {
+		return errors.New("kafka brokers not configured")
+	}
+	acks := settings.KafkaAcks
+	if len(acks) == 0 {
+		acks = "all"
+	}
+	if _, found := kafkaAcks[acks]; !found {
+		return errors.Errorf("unknown kafka acks %s", acks)
+	}
+	if k.topicTemplate, err = parseTemplate("kafka topic", settings.KafkaTopic, defaultKafkaTopic); err != nil {
+		return err
+	}
+	if k.keyTemplate, err = parseTemplate("kafka key", settings.KafkaKey, "{{.Route}}"); err != nil {
+		return err
+	}
+	if k.timeout, err = iface.ParseDuration(settings.KafkaTimeout, 10*time.Second); err != nil {
+		return err
+	}
+	k.writer = &kafka.Writer{
+		Addr:         kafka.TCP(settings.KafkaBrokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafkaAcks[acks],
+		WriteTimeout: k.timeout,
+		BatchSize:    1,
+	}
+	k.route = settings.URI
+	k.debug = settings.Debug
+	return nil
+}
+
+// Save publishes the payload and waits for the configured acknowledgement.
+func (k *KafkaSaver) Save(payload *iface.PayloadRecord) error {
+	data := &recordData{Route: k.route, PayloadRecord: payload}
+	topic, err := executeTemplate(k.topicTemplate, data)
+	if err != nil {
+		return err
+	}
+	key, err := executeTemplate(k.keyTemplate, data)
+	if err != nil {
+		return err
+	}
+	value, err := encodeRecord("json", data)
+	if err != nil {
+		return err
+	}
+	if k.debug {
+		log.Printf("KafkaSaver_Save: publishing to %s with key %s", topic, key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	err = k.writer.WriteMessages(ctx, kafka.Message{Topic: topic, Key: []byte(key), Value: value, Time: payload.Timestamp})
+	return errors.Wrapf(err, "error publishing payload to kafka topic %s", topic)
+}
+
+// Health asks the brokers for metadata of the cluster.
+func (k *KafkaSaver) Health() error {
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	client := &kafka.Client{Addr: k.writer.Addr, Transport: k.writer.Transport, Timeout: k.timeout}
+	_, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+	return errors.Wrap(err, "error reaching kafka brokers")
+}
+
+// Close closes connections to the brokers.
+func (k *KafkaSaver) Close() error {
+	return k.writer.Close()
+}
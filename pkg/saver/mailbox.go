@@ -0,0 +1,197 @@
+package saver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/email"
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/parser"
+)
+
+const (
+	defaultMaildirPath = "glutton.maildir"
+	defaultMboxPath    = "glutton.mbox"
+	defaultMailboxFrom = "glutton@localhost"
+)
+
+// mailbox holds what both mailbox savers share, the addresses of messages.
+type mailbox struct {
+	from  string
+	to    []string
+	route string
+}
+
+func (m *mailbox) configure(settings *iface.Settings) {
+	m.from = settings.MailboxFrom
+	if len(m.from) == 0 {
+		m.from = defaultMailboxFrom
+	}
+	m.to = settings.MailboxTo
+	m.route = settings.URI
+}
+
+// message renders the payload as an email. Form fields make up the body and uploaded files are attached, other payload is put in the body as it is (or attached if not text).
+func (m *mailbox) message(payload *iface.PayloadRecord) ([]byte, error) {
+	form, err := parser.ParseForm(payload)
+	if err != nil {
+		// not a valid form, keep the payload as it is
+		form = &parser.Form{}
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "Received %s from %s\n%s %s\n\n", payload.Timestamp.Format(time.RFC1123Z), payload.Remote, payload.Method, payload.URL)
+	message := &email.Message{
+		From:    m.from,
+		To:      m.to,
+		Subject: "glutton " + m.route + " submission",
+		Date:    payload.Timestamp,
+		Header:  map[string]string{"X-Glutton-Route": m.route},
+	}
+	for _, name := range form.Names() {
+		for _, value := range form.Fields[name] {
+			fmt.Fprintf(&text, "%s: %s\n", name, strings.ReplaceAll(value, "\n", "\n    "))
+		}
+	}
+	for _, file := range form.Files {
+		message.Attachments = append(message.Attachments, email.Attachment{Filename: file.Filename, ContentType: file.ContentType, Data: file.Data})
+	}
+	if len(form.Fields) == 0 && len(form.Files) == 0 && len(payload.Payload) > 0 {
+		if utf8.ValidString(payload.Payload) {
+			text.WriteString(payload.Payload)
+			text.WriteString("\n")
+		} else {
+			contentType := ""
+			if values := payload.Meta["Content-Type"]; len(values) > 0 {
+				contentType = values[0]
+			}
+			message.Attachments = append(message.Attachments, email.Attachment{Filename: "payload.bin", ContentType: contentType, Data: []byte(payload.Payload)})
+		}
+	}
+	message.Text = text.String()
+	return message.Bytes()
+}
+
+// MaildirSaver delivers payload as email messages into a Maildir.
+type MaildirSaver struct {
+	mailbox
+	root     string
+	hostname string
+	counter  int64
+	debug    bool
+}
+
+// Configure creates the Maildir (tmp, new and cur folders) if missing.
+// Namely the following params are used:
+// * MaildirPath - the Maildir
+// * MailboxFrom, MailboxTo - addresses of the messages
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (s *MaildirSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("MaildirSaver", settings); err != nil {
+		return err
+	}
+	s.configure(settings)
+	s.root = settings.MaildirPath
+	if len(s.root) == 0 {
+		s.root = defaultMaildirPath
+	}
+	s.debug = settings.Debug
+	if s.hostname, err = os.Hostname(); err != nil {
+		s.hostname = "glutton"
+	}
+	// slashes and colons have a meaning in Maildir file names
+	s.hostname = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(s.hostname)
+	for _, folder := range []string{"tmp", "new", "cur"} {
+		if err = os.MkdirAll(filepath.Join(s.root, folder), 0700); err != nil {
+			return errors.Wrapf(err, "error creating maildir %s", s.root)
+		}
+	}
+	return nil
+}
+
+// Save writes the message to tmp and moves it to new, as the Maildir delivery goes.
+func (s *MaildirSaver) Save(payload *iface.PayloadRecord) error {
+	message, err := s.message(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddInt64(&s.counter, 1), s.hostname)
+	tmp := filepath.Join(s.root, "tmp", name)
+	if err = ioutil.WriteFile(tmp, message, 0600); err != nil {
+		return errors.Wrapf(err, "error writing message %s", tmp)
+	}
+	if err = os.Rename(tmp, filepath.Join(s.root, "new", name)); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "error delivering message %s", name)
+	}
+	return nil
+}
+
+// fromLine matches lines to be quoted in mbox (mboxrd).
+var fromLine = regexp.MustCompile(`(?m)^(>*From )`)
+
+// MboxSaver appends payload as email messages to an mbox file (mboxrd).
+type MboxSaver struct {
+	mailbox
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+	debug bool
+}
+
+// Configure opens (creates) the mbox file.
+// Namely the following params are used:
+// * MboxPath - the mbox file
+// * MailboxFrom, MailboxTo - addresses of the messages
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (s *MboxSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("MboxSaver", settings); err != nil {
+		return err
+	}
+	s.configure(settings)
+	s.path = settings.MboxPath
+	if len(s.path) == 0 {
+		s.path = defaultMboxPath
+	}
+	s.debug = settings.Debug
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	return errors.Wrapf(err, "error opening mbox %s", s.path)
+}
+
+// Save appends the message, lines starting with `From ` are quoted.
+func (s *MboxSaver) Save(payload *iface.PayloadRecord) error {
+	message, err := s.message(payload)
+	if err != nil {
+		return err
+	}
+	var entry bytes.Buffer
+	fmt.Fprintf(&entry, "From %s %s\n", strings.Trim(s.from, "<>"), payload.Timestamp.UTC().Format(time.ANSIC))
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	entry.Write(fromLine.ReplaceAll(message, []byte(">$1")))
+	if !bytes.HasSuffix(message, []byte("\n")) {
+		entry.WriteString("\n")
+	}
+	entry.WriteString("\n")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(entry.Bytes())
+	return errors.Wrapf(err, "error appending to mbox %s", s.path)
+}
+
+// Close closes the mbox file.
+func (s *MboxSaver) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,102 @@
+package saver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func formPayload(t *testing.T) *iface.PayloadRecord {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("name", "John")
+	w.WriteField("message", "From here\nto there")
+	part, err := w.CreateFormFile("cv", "cv.pdf")
+	assert.NoError(t, err)
+	part.Write([]byte("%PDF"))
+	w.Close()
+	return &iface.PayloadRecord{
+		Payload:   body.String(),
+		Timestamp: time.Now(),
+		Remote:    "127.0.0.1:1234",
+		Method:    "POST",
+		URL:       "http://localhost/v1/glutton/contact",
+		Meta:      map[string][]string{"Content-Type": {w.FormDataContentType()}},
+	}
+}
+
+func TestMaildirSaver_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := new(MaildirSaver)
+	assert.NoError(t, s.Configure(&iface.Settings{URI: "contact", MaildirPath: dir, MailboxTo: []string{"support@example.com"}}))
+	assert.NoError(t, s.Save(formPayload(t)))
+	assert.NoError(t, s.Save(&iface.PayloadRecord{Payload: "plain text", Timestamp: time.Now()}))
+	files, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	tmp, _ := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	assert.Empty(t, tmp)
+	bodies := []string{}
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(dir, "new", file.Name()))
+		assert.NoError(t, err)
+		msg, err := mail.ReadMessage(f)
+		assert.NoError(t, err)
+		assert.Equal(t, "support@example.com", msg.Header.Get("To"))
+		assert.Equal(t, "contact", msg.Header.Get("X-Glutton-Route"))
+		mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if mediaType == "multipart/mixed" {
+			parts := multipart.NewReader(msg.Body, params["boundary"])
+			text, err := parts.NextPart()
+			assert.NoError(t, err)
+			content, _ := ioutil.ReadAll(text)
+			bodies = append(bodies, string(content))
+			attachment, err := parts.NextPart()
+			assert.NoError(t, err)
+			assert.Equal(t, "cv.pdf", attachment.FileName())
+		} else {
+			content, _ := ioutil.ReadAll(msg.Body)
+			bodies = append(bodies, string(content))
+		}
+		f.Close()
+	}
+	all := strings.Join(bodies, "\n")
+	assert.Contains(t, all, "name: John")
+	assert.Contains(t, all, "message: From here\r\n    to there")
+	assert.Contains(t, all, "plain text")
+}
+
+func TestMboxSaver_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.mbox")
+	s := new(MboxSaver)
+	assert.NoError(t, s.Configure(&iface.Settings{URI: "contact", MboxPath: path}))
+	assert.NoError(t, s.Save(&iface.PayloadRecord{Payload: "From the start\nmiddle", Timestamp: time.Now()}))
+	assert.NoError(t, s.Save(formPayload(t)))
+	assert.NoError(t, s.Close())
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	messages := strings.Split(string(content), "\n\nFrom glutton@localhost ")
+	assert.Len(t, messages, 2)
+	assert.True(t, strings.HasPrefix(messages[0], "From glutton@localhost "))
+	assert.Contains(t, messages[0], "\n>From the start\n")
+	assert.NotContains(t, string(content), "\r\n")
+	// the first line is the mbox separator
+	msg, err := mail.ReadMessage(strings.NewReader(messages[1][strings.Index(messages[1], "\n")+1:]))
+	assert.NoError(t, err)
+	assert.Equal(t, "glutton@localhost", msg.Header.Get("From"))
+}
@@ -0,0 +1,47 @@
+package saver
+
+import (
+	"strings"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+// MultiSaver runs several savers one after another, e.g. to forward a payload and keep a copy of it. A failing saver doesn't stop the others. The first saver is the primary one, the payload counts as saved if it succeeds.
+type MultiSaver struct {
+	Savers []iface.PayloadSaver
+}
+
+// Configure does nothing, the savers are expected to be configured already.
+func (m *MultiSaver) Configure(*iface.Settings) error {
+	return nil
+}
+
+// multiSaveError collects errors of the savers, it tells whether the primary saver succeeded.
+type multiSaveError struct {
+	messages []string
+	saved    bool
+}
+
+func (e *multiSaveError) Error() string {
+	return "error saving payload: " + strings.Join(e.messages, "; ")
+}
+
+// Saved tells whether the primary saver stored the payload.
+func (e *multiSaveError) Saved() bool {
+	return e.saved
+}
+
+// Save passes payload to all savers in order, errors are collected and returned together.
+func (m *MultiSaver) Save(payload *iface.PayloadRecord) error {
+	failed := &multiSaveError{saved: true}
+	for i, saver := range m.Savers {
+		if err := saver.Save(payload); err != nil {
+			failed.messages = append(failed.messages, err.Error())
+			failed.saved = failed.saved && i > 0
+		}
+	}
+	if len(failed.messages) > 0 {
+		return failed
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package saver
+
+import (
+	"log"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const defaultNATSSubject = "glutton.{{.Route}}"
+
+// NATSSaver publishes payload as JSON to a NATS subject. The route is turned into subject tokens for the template (`/v1/contact` becomes `v1.contact`).
+type NATSSaver struct {
+	conn            *nats.Conn
+	jetStream       nats.JetStreamContext
+	subjectTemplate *template.Template
+	route           string
+	timeout         time.Duration
+	debug           bool
+}
+
+// Configure bootstraps the NATSSaver.
+// Namely the following params are used:
+// * NATSURL - server(s) to connect to
+// * NATSSubject - template of the subject
+// * NATSJetStream - publish to JetStream and wait for the acknowledgement of the stream, otherwise messages are fire and forget
+// * NATSTimeout - how long to wait for the server
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+// An unreachable server doesn't stop glutton, the connection is retried in the background and reported by Health.
+func (n *NATSSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("NATSSaver", settings); err != nil {
+		return err
+	}
+	if n.subjectTemplate, err = parseTemplate("nats subject", settings.NATSSubject, defaultNATSSubject); err != nil {
+		return err
+	}
+	if n.timeout, err = iface.ParseDuration(settings.NATSTimeout, 10*time.Second); err != nil {
+		return err
+	}
+	url := settings.NATSURL
+	if len(url) == 0 {
+		url = nats.DefaultURL
+	}
+	n.conn, err = nats.Connect(url, nats.Name("glutton "+settings.Name), nats.Timeout(n.timeout), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return errors.Wrapf(err, "error connecting to nats %s", url)
+	}
+	if !n.conn.IsConnected() {
+		log.Printf("NATSSaver_Configure: nats %s not reachable, connecting in the background", url)
+	}
+	if settings.NATSJetStream {
+		if n.jetStream, err = n.conn.JetStream(nats.MaxWait(n.timeout)); err != nil {
+			return errors.Wrap(err, "error creating jetstream context")
+		}
+	}
+	n.route = settings.URI
+	n.debug = settings.Debug
+	return nil
+}
+
+// Save publishes the payload. With JetStream it waits for the stream to store the message, otherwise it waits for the server to receive it. It fails right away while disconnected, rather than buffering the message.
+func (n *NATSSaver) Save(payload *iface.PayloadRecord) error {
+	if !n.conn.IsConnected() {
+		return errors.Errorf("error publishing payload, nats connection %s", n.conn.Status())
+	}
+	subject, err := executeTemplate(n.subjectTemplate, &recordData{Route: subjectTokens(n.route), PayloadRecord: payload})
+	if err != nil {
+		return err
+	}
+	if err = validSubject(subject); err != nil {
+		return err
+	}
+	value, err := encodeRecord("json", &recordData{Route: n.route, PayloadRecord: payload})
+	if err != nil {
+		return err
+	}
+	if n.debug {
+		log.Printf("NATSSaver_Save: publishing to %s", subject)
+	}
+	if n.jetStream != nil {
+		_, err = n.jetStream.Publish(subject, value)
+		return errors.Wrapf(err, "error publishing payload to jetstream subject %s", subject)
+	}
+	if err = n.conn.Publish(subject, value); err != nil {
+		return errors.Wrapf(err, "error publishing payload to nats subject %s", subject)
+	}
+	return errors.Wrap(n.conn.FlushTimeout(n.timeout), "error flushing nats connection")
+}
+
+// Health reports the state of the connection.
+func (n *NATSSaver) Health() error {
+	if !n.conn.IsConnected() {
+		return errors.Errorf("nats connection %s", n.conn.Status())
+	}
+	return nil
+}
+
+// Close drains and closes the connection, a connection not established is just closed.
+func (n *NATSSaver) Close() error {
+	if !n.conn.IsConnected() {
+		n.conn.Close()
+		return nil
+	}
+	return n.conn.Drain()
+}
+
+// subjectTokens turns a route into subject tokens, path segments become tokens and characters not allowed in tokens are replaced by `_`.
+func subjectTokens(route string) string {
+	var tokens []string
+	for _, segment := range strings.Split(route, "/") {
+		if len(segment) == 0 {
+			continue
+		}
+		tokens = append(tokens, strings.Map(func(r rune) rune {
+			if r <= ' ' || r == '.' || r == '*' || r == '>' || r == 0x7f {
+				return '_'
+			}
+			return r
+		}, segment))
+	}
+	if len(tokens) == 0 {
+		return "_"
+	}
+	return strings.Join(tokens, ".")
+}
+
+// validSubject checks the subject can be published to, it must not have empty tokens, wildcards nor whitespace.
+func validSubject(subject string) error {
+	for _, token := range strings.Split(subject, ".") {
+		if len(token) == 0 || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return errors.Errorf("invalid nats subject %q", subject)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package saver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	defaultRedisURL = "redis://localhost:6379/0"
+	defaultRedisKey = "glutton:{{.Route}}"
+	// redisStream appends records to a stream (XADD), one field per record attribute.
+	redisStream = "stream"
+	// redisList pushes records encoded as JSON to a list (RPUSH).
+	redisList = "list"
+)
+
+// RedisSaver saves payload to a Redis stream or list.
+type RedisSaver struct {
+	client      *redis.Client
+	mode        string
+	keyTemplate *template.Template
+	maxLen      int64
+	route       string
+	timeout     time.Duration
+	debug       bool
+}
+
+// Configure bootstraps the RedisSaver.
+// Namely the following params are used:
+// * RedisURL - server to connect to, e.g. redis://:password@localhost:6379/0
+// * RedisMode - `stream` (default) or `list`
+// * RedisKey - template of the stream (list) name
+// * RedisMaxLen - trim the stream (list) to roughly this many records, 0 means no trimming
+// * RedisTimeout - how long to wait for the server
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (r *RedisSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("RedisSaver", settings); err != nil {
+		return err
+	}
+	r.mode = settings.RedisMode
+	if len(r.mode) == 0 {
+		r.mode = redisStream
+	}
+	if r.mode != redisStream && r.mode != redisList {
+		return errors.Errorf("unknown redis mode %s", r.mode)
+	}
+	if r.keyTemplate, err = parseTemplate("redis key", settings.RedisKey, defaultRedisKey); err != nil {
+		return err
+	}
+	if r.timeout, err = iface.ParseDuration(settings.RedisTimeout, 5*time.Second); err != nil {
+		return err
+	}
+	url := settings.RedisURL
+	if len(url) == 0 {
+		url = defaultRedisURL
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing redis url %s", url)
+	}
+	r.client = redis.NewClient(options)
+	r.maxLen = int64(settings.RedisMaxLen)
+	r.route = settings.URI
+	r.debug = settings.Debug
+	return nil
+}
+
+// Save adds the payload to the stream (list).
+func (r *RedisSaver) Save(payload *iface.PayloadRecord) error {
+	data := &recordData{Route: r.route, PayloadRecord: payload}
+	key, err := executeTemplate(r.keyTemplate, data)
+	if err != nil {
+		return err
+	}
+	if r.debug {
+		log.Printf("RedisSaver_Save: adding payload to %s %s", r.mode, key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if r.mode == redisList {
+		return r.push(ctx, key, data)
+	}
+	meta, err := json.Marshal(payload.Meta)
+	if err != nil {
+		return errors.Wrap(err, "error encoding meta")
+	}
+	err = r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: r.maxLen,
+		Approx: true,
+		Values: []interface{}{
+			"route", r.route,
+			"timestamp", payload.Timestamp.Format(time.RFC3339Nano),
+			"remote", payload.Remote,
+			"method", payload.Method,
+			"meta", string(meta),
+			"payload", payload.Payload,
+		},
+	}).Err()
+	return errors.Wrapf(err, "error adding payload to redis stream %s", key)
+}
+
+// push appends record to a list, trimming it afterwards if configured.
+func (r *RedisSaver) push(ctx context.Context, key string, data *recordData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "error encoding record")
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, encoded)
+		if r.maxLen > 0 {
+			pipe.LTrim(ctx, key, -r.maxLen, -1)
+		}
+		return nil
+	})
+	return errors.Wrapf(err, "error pushing payload to redis list %s", key)
+}
+
+// Health pings the server.
+func (r *RedisSaver) Health() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return errors.Wrap(r.client.Ping(ctx).Err(), "error pinging redis")
+}
+
+// Close closes connections to the server.
+func (r *RedisSaver) Close() error {
+	return r.client.Close()
+}
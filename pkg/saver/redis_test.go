@@ -0,0 +1,53 @@
+package saver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisSaver_Stream(t *testing.T) {
+	server := miniredis.RunT(t)
+	r := new(RedisSaver)
+	assert.NoError(t, r.Configure(&iface.Settings{URI: "save", RedisURL: "redis://" + server.Addr(), RedisMaxLen: 100}))
+	defer r.Close()
+	assert.NoError(t, r.Health())
+	err := r.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now(), Method: "POST", Meta: map[string][]string{"Content-Type": {"text/plain"}}})
+	assert.NoError(t, err)
+	entries, err := server.Stream("glutton:save")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	values := map[string]string{}
+	for i := 0; i < len(entries[0].Values); i += 2 {
+		values[entries[0].Values[i]] = entries[0].Values[i+1]
+	}
+	assert.Equal(t, "save", values["route"])
+	assert.Equal(t, "POST", values["method"])
+	assert.Equal(t, "test payload", values["payload"])
+	assert.JSONEq(t, `{"Content-Type":["text/plain"]}`, values["meta"])
+}
+
+func TestRedisSaver_List(t *testing.T) {
+	server := miniredis.RunT(t)
+	r := new(RedisSaver)
+	assert.NoError(t, r.Configure(&iface.Settings{URI: "save", RedisURL: "redis://" + server.Addr(), RedisMode: "list", RedisKey: "captured", RedisMaxLen: 2}))
+	defer r.Close()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, r.Save(&iface.PayloadRecord{Payload: "test payload", Timestamp: time.Now()}))
+	}
+	list, err := server.List("captured")
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	record := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(list[0]), &record))
+	assert.Equal(t, "test payload", record["payload"])
+	assert.Equal(t, "save", record["route"])
+}
+
+func TestRedisSaver_UnknownMode(t *testing.T) {
+	assert.Error(t, new(RedisSaver).Configure(&iface.Settings{RedisMode: "set"}))
+}
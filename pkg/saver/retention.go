@@ -0,0 +1,334 @@
+package saver
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+// retentionPolicy limits how much saved payload is kept, zero values mean no limit.
+type retentionPolicy struct {
+	maxAge   time.Duration
+	maxCount int
+	maxBytes int64
+}
+
+// keep tells whether a record is to be kept. Records are checked newest first, count and total are those of the record and all newer ones.
+func (p *retentionPolicy) keep(now, timestamp time.Time, count int, total int64) bool {
+	if p.maxAge > 0 && now.Sub(timestamp) > p.maxAge {
+		return false
+	}
+	if p.maxCount > 0 && count > p.maxCount {
+		return false
+	}
+	return p.maxBytes <= 0 || total <= p.maxBytes
+}
+
+// janitor enforces a retention policy in the background.
+type janitor struct {
+	name     string
+	policy   *retentionPolicy
+	purge    func(*retentionPolicy) error
+	interval time.Duration
+	done     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// startJanitor starts purging right away and then every RetentionInterval. It returns nil if no retention is configured. Purge errors are only logged, name is used to tell the janitors apart in the log.
+func startJanitor(name string, settings *iface.Settings, purge func(*retentionPolicy) error) (*janitor, error) {
+	j, err := newJanitor(name, settings, purge)
+	if j != nil {
+		j.start()
+	}
+	return j, err
+}
+
+// newJanitor creates a janitor to be started later, nil if no retention is configured.
+func newJanitor(name string, settings *iface.Settings, purge func(*retentionPolicy) error) (*janitor, error) {
+	maxAge, err := iface.ParseDuration(settings.RetentionMaxAge, 0)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := iface.ParseDuration(settings.RetentionInterval, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if maxAge <= 0 && settings.RetentionMaxCount <= 0 && settings.RetentionMaxBytes <= 0 {
+		return nil, nil
+	}
+	if interval <= 0 {
+		return nil, errors.Errorf("retention interval must be positive, got %s", interval)
+	}
+	return &janitor{
+		name:     name,
+		policy:   &retentionPolicy{maxAge: maxAge, maxCount: settings.RetentionMaxCount, maxBytes: int64(settings.RetentionMaxBytes)},
+		purge:    purge,
+		interval: interval,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// start starts purging in the background.
+func (j *janitor) start() {
+	j.stopped.Add(1)
+	go j.run(j.interval)
+}
+
+// Close stops the janitor, a purge in progress is finished first.
+func (j *janitor) Close() {
+	if j == nil {
+		return
+	}
+	close(j.done)
+	j.stopped.Wait()
+}
+
+func (j *janitor) run(interval time.Duration) {
+	defer j.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := j.purge(j.policy); err != nil {
+			log.Printf("%s: error purging %+v", j.name, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// formatVerb matches the counter verb of a base name.
+var formatVerb = regexp.MustCompile(`%[-+# 0-9]*[dvxX]`)
+
+// filePatterns holds the savers writing files matching a pattern. Retention can't tell apart files of routes sharing the pattern.
+var filePatterns = struct {
+	sync.Mutex
+	savers map[string][]*SimpleFileSystemSaver
+}{savers: map[string][]*SimpleFileSystemSaver{}}
+
+// pattern matches all files the saver writes.
+func (s *SimpleFileSystemSaver) pattern() string {
+	return filepath.Join(s.root, formatVerb.ReplaceAllString(s.basename, "*"))
+}
+
+// claimFiles registers the saver's files, it fails if they are shared with another route and either has retention.
+func (s *SimpleFileSystemSaver) claimFiles() error {
+	filePatterns.Lock()
+	defer filePatterns.Unlock()
+	pattern := s.pattern()
+	for _, other := range filePatterns.savers[pattern] {
+		if s.janitor != nil || other.janitor != nil {
+			return errors.Errorf("route %s shares output folder and base name (%s) with route %s, retention would remove the files of both", s.uri, pattern, other.uri)
+		}
+	}
+	filePatterns.savers[pattern] = append(filePatterns.savers[pattern], s)
+	return nil
+}
+
+// releaseFiles unregisters the saver's files.
+func (s *SimpleFileSystemSaver) releaseFiles() {
+	filePatterns.Lock()
+	defer filePatterns.Unlock()
+	pattern := s.pattern()
+	savers := filePatterns.savers[pattern]
+	for i, other := range savers {
+		if other == s {
+			savers = append(savers[:i], savers[i+1:]...)
+			break
+		}
+	}
+	if len(savers) == 0 {
+		delete(filePatterns.savers, pattern)
+		return
+	}
+	filePatterns.savers[pattern] = savers
+}
+
+// sharedTables holds the database savers writing to a table. Retention can't tell apart records of routes sharing a table unless the route is stored.
+var sharedTables = struct {
+	sync.Mutex
+	savers map[string][]*DatabaseSaver
+}{savers: map[string][]*DatabaseSaver{}}
+
+// purgesAll tells whether the saver's retention purges records of every route in its table.
+func (ds *DatabaseSaver) purgesAll() bool {
+	return ds.janitor != nil && !ds.routeColumn
+}
+
+// claimTable registers the saver's table, it fails if the table is shared with another route and either purges records of both.
+func (ds *DatabaseSaver) claimTable() error {
+	sharedTables.Lock()
+	defer sharedTables.Unlock()
+	for _, other := range sharedTables.savers[ds.tableKey] {
+		if ds.purgesAll() || other.purgesAll() {
+			return errors.Errorf("route %s shares table %s with route %s, retention would remove the records of both unless the route is stored (SQLAutoMigrate or a layout with :route)", ds.route, ds.table, other.route)
+		}
+	}
+	sharedTables.savers[ds.tableKey] = append(sharedTables.savers[ds.tableKey], ds)
+	return nil
+}
+
+// releaseTable unregisters the saver's table.
+func (ds *DatabaseSaver) releaseTable() {
+	sharedTables.Lock()
+	defer sharedTables.Unlock()
+	savers := sharedTables.savers[ds.tableKey]
+	for i, other := range savers {
+		if other == ds {
+			savers = append(savers[:i], savers[i+1:]...)
+			break
+		}
+	}
+	if len(savers) == 0 {
+		delete(sharedTables.savers, ds.tableKey)
+		return
+	}
+	sharedTables.savers[ds.tableKey] = savers
+}
+
+// purgeFiles removes files written by the saver that are beyond the policy, oldest first.
+func (s *SimpleFileSystemSaver) purgeFiles(policy *retentionPolicy) error {
+	pattern := s.pattern()
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "error listing %s", pattern)
+	}
+	files := make([]os.FileInfo, 0, len(names))
+	paths := map[os.FileInfo]string{}
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, info)
+		paths[info] = name
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	var (
+		now   = time.Now()
+		total int64
+	)
+	for i, info := range files {
+		total += info.Size()
+		if policy.keep(now, info.ModTime(), i+1, total) {
+			continue
+		}
+		if err = os.Remove(paths[info]); err != nil {
+			return errors.Wrapf(err, "error removing %s", paths[info])
+		}
+		log.Printf("SimpleFileSystemSaver: retention removed %s (%d bytes, modified %s)", paths[info], info.Size(), info.ModTime().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// tableRetention describes a table purged by the database savers. Records are told apart by their timestamp, the column should be indexed.
+type tableRetention struct {
+	db        *sql.DB
+	dialect   *dialect
+	table     string
+	timestamp string
+	body      string
+	// route limits purging to records of a single route, empty to purge the whole table
+	route string
+}
+
+// check makes sure the table has the columns purging needs.
+func (t *tableRetention) check() error {
+	columns := t.timestamp + ", " + t.body
+	if len(t.route) > 0 {
+		columns += ", route"
+	}
+	rows, err := t.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", columns, t.table))
+	if err != nil {
+		return errors.Wrapf(err, "retention needs the columns %s in table %s", columns, t.table)
+	}
+	return rows.Close()
+}
+
+// purge removes records beyond the policy. Records over the maximum age are deleted right away, the count and size limits need the records to be read newest first, up to the first one beyond the limits.
+func (t *tableRetention) purge(policy *retentionPolicy) error {
+	now := time.Now()
+	if policy.maxAge > 0 {
+		if err := t.delete("<", t.dialect.placeholder(1), now.Add(-policy.maxAge)); err != nil {
+			return err
+		}
+	}
+	if policy.maxCount <= 0 && policy.maxBytes <= 0 {
+		return nil
+	}
+	where, args := "", []interface{}{}
+	if len(t.route) > 0 {
+		where, args = " WHERE route = "+t.dialect.placeholder(1), append(args, t.route)
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s%s ORDER BY %s DESC", t.timestamp, fmt.Sprintf(t.dialect.byteLength, "COALESCE("+t.body+", '')"), t.table, where, t.timestamp)
+	rows, err := t.db.Query(query, args...)
+	if err != nil {
+		return errors.Wrapf(err, "error listing records of %s", t.table)
+	}
+	var (
+		count        int
+		total        int64
+		kept, cutoff time.Time
+		found        bool
+	)
+	for rows.Next() {
+		var (
+			timestamp time.Time
+			size      int64
+		)
+		if err = rows.Scan(&timestamp, &size); err != nil {
+			rows.Close()
+			return errors.Wrapf(err, "error reading records of %s", t.table)
+		}
+		count++
+		total += size
+		if !policy.keep(now, timestamp, count, total) {
+			cutoff, found = timestamp, true
+			break
+		}
+		kept = timestamp
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return errors.Wrapf(err, "error reading records of %s", t.table)
+	}
+	if !found {
+		return nil
+	}
+	// everything as old as the first record beyond the limits goes, unless a record kept is as old. The database picks the timestamp, as it stores it.
+	op := "<="
+	if count > 1 && cutoff.Equal(kept) {
+		op = "<"
+	}
+	value := fmt.Sprintf("(SELECT %[1]s FROM (SELECT %[1]s FROM %[2]s%[3]s ORDER BY %[1]s DESC LIMIT 1 OFFSET %[4]d) cutoff)", t.timestamp, t.table, where, count-1)
+	return t.delete(op, value, args...)
+}
+
+// delete removes records (of the route) with the timestamp compared by op to value, an expression using args.
+func (t *tableRetention) delete(op, value string, args ...interface{}) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s %s %s", t.table, t.timestamp, op, value)
+	if len(t.route) > 0 {
+		query += " AND route = " + t.dialect.placeholder(len(args)+1)
+		args = append(args, t.route)
+	}
+	result, err := t.db.Exec(query, args...)
+	if err != nil {
+		return errors.Wrapf(err, "error purging %s", t.table)
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		log.Printf("retention removed %d records of %s (route %q)", removed, t.table, t.route)
+	}
+	return nil
+}
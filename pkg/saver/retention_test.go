@@ -0,0 +1,171 @@
+package saver
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionPolicy_keep(t *testing.T) {
+	now := time.Now()
+	policy := &retentionPolicy{maxAge: time.Hour, maxCount: 2, maxBytes: 100}
+	assert.True(t, policy.keep(now, now, 1, 10))
+	assert.False(t, policy.keep(now, now.Add(-2*time.Hour), 1, 10))
+	assert.False(t, policy.keep(now, now, 3, 10))
+	assert.False(t, policy.keep(now, now, 1, 101))
+	assert.True(t, new(retentionPolicy).keep(now, now.Add(-1000*time.Hour), 1000, 1<<40))
+}
+
+func TestSimpleFileSystemSaver_purgeFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := new(SimpleFileSystemSaver)
+	assert.NoError(t, s.Configure(&iface.Settings{OutputFolder: dir, BaseName: "glutton_%d"}))
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, s.Save(&iface.PayloadRecord{Payload: "payload", Timestamp: time.Now()}))
+		// oldest first
+		modified := time.Now().Add(time.Duration(i-5) * time.Hour)
+		assert.NoError(t, os.Chtimes(s.filename(int64(i+1)), modified, modified))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other"), []byte("not ours"), 0644))
+	assert.NoError(t, s.purgeFiles(&retentionPolicy{maxCount: 4}))
+	assert.NoFileExists(t, s.filename(1))
+	assert.NoError(t, s.purgeFiles(&retentionPolicy{maxAge: 150 * time.Minute}))
+	for i := 2; i <= 3; i++ {
+		assert.NoFileExists(t, s.filename(int64(i)))
+	}
+	info, err := os.Stat(s.filename(5))
+	assert.NoError(t, err)
+	assert.NoError(t, s.purgeFiles(&retentionPolicy{maxBytes: info.Size()}))
+	assert.NoFileExists(t, s.filename(4))
+	assert.FileExists(t, s.filename(5))
+	assert.FileExists(t, filepath.Join(dir, "other"))
+}
+
+func TestSQLiteSaver_purge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	save := new(SQLiteSaver)
+	assert.NoError(t, save.Configure(&iface.Settings{URI: "save", SQLitePath: path}))
+	defer save.Close()
+	other := new(SQLiteSaver)
+	assert.NoError(t, other.Configure(&iface.Settings{URI: "other", SQLitePath: path}))
+	defer other.Close()
+	for i := 0; i < 5; i++ {
+		payload := &iface.PayloadRecord{Payload: fmt.Sprintf("payload %d", i), Timestamp: time.Now().Add(time.Duration(i-5) * time.Hour)}
+		assert.NoError(t, save.Save(payload))
+		assert.NoError(t, other.Save(payload))
+	}
+	retention := &tableRetention{db: save.db, dialect: sqliteDialect, table: "payload", timestamp: "timestamp", body: "body", route: "save"}
+	assert.NoError(t, retention.purge(&retentionPolicy{maxCount: 4}))
+	assert.Equal(t, []string{"payload 1", "payload 2", "payload 3", "payload 4"}, sqliteBodies(t, save, "save"))
+	assert.NoError(t, retention.purge(&retentionPolicy{maxAge: 150 * time.Minute}))
+	assert.Equal(t, []string{"payload 3", "payload 4"}, sqliteBodies(t, save, "save"))
+	assert.NoError(t, retention.purge(&retentionPolicy{maxBytes: 10}))
+	assert.Equal(t, []string{"payload 4"}, sqliteBodies(t, save, "save"))
+	assert.Len(t, sqliteBodies(t, save, "other"), 5)
+}
+
+func sqliteBodies(t *testing.T, s *SQLiteSaver, route string) []string {
+	rows, err := s.db.Query("SELECT body FROM payload WHERE route = ? ORDER BY id", route)
+	assert.NoError(t, err)
+	defer rows.Close()
+	bodies := []string{}
+	for rows.Next() {
+		var body []byte
+		assert.NoError(t, rows.Scan(&body))
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestSimpleFileSystemSaver_SharedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	first := new(SimpleFileSystemSaver)
+	assert.NoError(t, first.Configure(&iface.Settings{URI: "first", OutputFolder: dir, BaseName: "glutton_%d"}))
+	second := new(SimpleFileSystemSaver)
+	assert.NoError(t, second.Configure(&iface.Settings{URI: "second", OutputFolder: dir, BaseName: "glutton_%d"}))
+	retained := new(SimpleFileSystemSaver)
+	err = retained.Configure(&iface.Settings{URI: "retained", OutputFolder: dir, BaseName: "glutton_%d", RetentionMaxCount: 10, RetentionInterval: "1h"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	first.Close()
+	second.Close()
+	assert.NoError(t, retained.Configure(&iface.Settings{URI: "retained", OutputFolder: dir, BaseName: "glutton_%d", RetentionMaxCount: 10, RetentionInterval: "1h"}))
+	defer retained.Close()
+	assert.Error(t, first.Configure(&iface.Settings{URI: "first", OutputFolder: dir, BaseName: "glutton_%d"}))
+}
+
+func TestDatabaseSaver_RetentionColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	db, err := sql.Open("sqlite", path)
+	assert.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE legacy (data TEXT)")
+	assert.NoError(t, err)
+	db.Close()
+	err = new(DatabaseSaver).Configure(&iface.Settings{
+		URI:                 "save",
+		SQLDriver:           "sqlite",
+		SQLConnectionString: path,
+		SQLTable:            "legacy",
+		SQLayout:            "INSERT INTO legacy(data) VALUES (:payload)",
+		RetentionMaxCount:   10,
+		RetentionInterval:   "1h",
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "retention needs the columns")
+}
+
+func TestDatabaseSaver_SharedTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+	db, err := sql.Open("sqlite", path)
+	assert.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE payload (ts TIMESTAMP, remote TEXT, meta TEXT, payload TEXT)")
+	assert.NoError(t, err)
+	db.Close()
+	settings := func(uri string, migrate bool, maxCount int) *iface.Settings {
+		table := "payload"
+		if migrate {
+			table = "routed"
+		}
+		return &iface.Settings{URI: uri, SQLDriver: "sqlite", SQLConnectionString: path, SQLTable: table, SQLAutoMigrate: migrate, RetentionMaxCount: maxCount, RetentionInterval: "1h"}
+	}
+	// the legacy layout doesn't store the route
+	first := new(DatabaseSaver)
+	assert.NoError(t, first.Configure(settings("first", false, 0)))
+	retained := new(DatabaseSaver)
+	err = retained.Configure(settings("retained", false, 10))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	first.Close()
+	retained = new(DatabaseSaver)
+	assert.NoError(t, retained.Configure(settings("retained", false, 10)))
+	assert.Error(t, new(DatabaseSaver).Configure(settings("first", false, 0)))
+	retained.Close()
+	// with the route stored each route purges its own records
+	first = new(DatabaseSaver)
+	assert.NoError(t, first.Configure(settings("first", true, 10)))
+	defer first.Close()
+	second := new(DatabaseSaver)
+	assert.NoError(t, second.Configure(settings("second", true, 10)))
+	defer second.Close()
+}
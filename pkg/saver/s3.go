@@ -0,0 +1,253 @@
+package saver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const (
+	defaultS3Endpoint    = "https://s3.amazonaws.com"
+	defaultS3Region      = "us-east-1"
+	defaultS3KeyTemplate = `{{.Route}}/{{.Timestamp.Format "2006/01/02"}}/{{.Timestamp.UnixNano}}-{{.Index}}`
+	s3Timeout            = 30 * time.Second
+)
+
+// S3Saver saves payload to an S3 compatible object storage (AWS S3, MinIO ...). Each record is stored as an object of its own unless batching is enabled, then a batch of records is stored as a single object.
+type S3Saver struct {
+	endpoint     *url.URL
+	region       string
+	bucket       string
+	accessKey    string
+	secretKey    string
+	pathStyle    bool
+	keyTemplate  *template.Template
+	format       string
+	storageClass string
+	sse          string
+	sseKMSKeyID  string
+	route        string
+	counter      int64
+	batcher      *batcher
+	client       *http.Client
+	debug        bool
+}
+
+// Configure bootstraps the S3Saver.
+// Namely the following params are used:
+// * S3Endpoint, S3Region, S3Bucket, S3AccessKey, S3SecretKey - where to store the objects
+// * S3PathStyle - address the bucket in path (MinIO) rather than in host name
+// * S3KeyTemplate - object key, a text/template with access to the record, its route and a counter (Index)
+// * S3Format - `text` or `json` (one record per line)
+// * S3StorageClass, S3SSE, S3SSEKMSKeyID - sent as x-amz-storage-class and x-amz-server-side-encryption headers
+// * S3BatchSize, S3FlushInterval - rotate batch objects after that many records or that time
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (s *S3Saver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("S3Saver", settings); err != nil {
+		return err
+	}
+	if len(settings.S3Bucket) == 0 {
+		return errors.New("s3 bucket not configured")
+	}
+	endpoint := settings.S3Endpoint
+	if len(endpoint) == 0 {
+		endpoint = defaultS3Endpoint
+	}
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return errors.Wrapf(err, "error parsing s3 endpoint %s", endpoint)
+	}
+	s.region = settings.S3Region
+	if len(s.region) == 0 {
+		s.region = defaultS3Region
+	}
+	if s.keyTemplate, err = parseTemplate("s3 key", settings.S3KeyTemplate, defaultS3KeyTemplate); err != nil {
+		return err
+	}
+	flushInterval, err := iface.ParseDuration(settings.S3FlushInterval, time.Minute)
+	if err != nil {
+		return err
+	}
+	s.bucket = settings.S3Bucket
+	s.accessKey = settings.S3AccessKey
+	s.secretKey = settings.S3SecretKey
+	s.pathStyle = settings.S3PathStyle
+	s.format = settings.S3Format
+	s.storageClass = settings.S3StorageClass
+	s.sse = settings.S3SSE
+	s.sseKMSKeyID = settings.S3SSEKMSKeyID
+	s.route = settings.URI
+	s.debug = settings.Debug
+	s.client = &http.Client{Timeout: s3Timeout}
+	if settings.S3BatchSize > 1 {
+		s.batcher = newBatcher("S3Saver "+s.route, settings.S3BatchSize, flushInterval, s.saveBatch)
+	}
+	return nil
+}
+
+// Save uploads the payload as a new object (or adds it to the current batch object).
+func (s *S3Saver) Save(payload *iface.PayloadRecord) error {
+	if s.batcher != nil {
+		return s.batcher.Add(payload)
+	}
+	return s.saveBatch([]*iface.PayloadRecord{payload})
+}
+
+// Close uploads the current batch.
+func (s *S3Saver) Close() error {
+	if s.batcher != nil {
+		return s.batcher.Close()
+	}
+	return nil
+}
+
+// Health fails while records of a failed batch upload wait for the next one.
+func (s *S3Saver) Health() error {
+	if s.batcher != nil {
+		return s.batcher.Health()
+	}
+	return nil
+}
+
+// saveBatch uploads records as a single object named after the first of them.
+func (s *S3Saver) saveBatch(records []*iface.PayloadRecord) error {
+	index := atomic.AddInt64(&s.counter, 1)
+	var body bytes.Buffer
+	for _, payload := range records {
+		encoded, err := encodeRecord(s.format, &recordData{Route: s.route, Index: index, PayloadRecord: payload})
+		if err != nil {
+			return err
+		}
+		body.Write(encoded)
+	}
+	key, err := executeTemplate(s.keyTemplate, &recordData{Route: s.route, Index: index, PayloadRecord: records[0]})
+	if err != nil {
+		return err
+	}
+	return s.put(strings.TrimPrefix(key, "/"), body.Bytes())
+}
+
+// put uploads a single object.
+func (s *S3Saver) put(key string, body []byte) error {
+	target := *s.endpoint
+	path := strings.TrimSuffix(target.Path, "/")
+	if s.pathStyle {
+		path += "/" + s.bucket
+	} else {
+		target.Host = s.bucket + "." + target.Host
+	}
+	target.Path = path + "/" + key
+	target.RawPath = escapeS3Path(target.Path)
+	if s.debug {
+		log.Printf("S3Saver_put: uploading %d bytes to %s", len(body), target.String())
+	}
+	req, err := http.NewRequest(http.MethodPut, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating request for %s", key)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.format == "json" {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if len(s.storageClass) > 0 {
+		req.Header.Set("X-Amz-Storage-Class", s.storageClass)
+	}
+	if len(s.sse) > 0 {
+		req.Header.Set("X-Amz-Server-Side-Encryption", s.sse)
+	}
+	if len(s.sseKMSKeyID) > 0 {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.sseKMSKeyID)
+	}
+	signV4(req, body, s.accessKey, s.secretKey, s.region, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error uploading %s", key)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("error uploading %s: %s %s", key, resp.Status, detail)
+	}
+	return nil
+}
+
+// signV4 signs the request with AWS signature version 4 (https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html).
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// escapeS3Path encodes everything but unreserved characters and slashes, as expected by signature version 4.
+func escapeS3Path(path string) string {
+	var builder strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			builder.WriteByte(c)
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return builder.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -8,14 +8,18 @@ import (
 	"sync/atomic"
 
 	"github.com/defectus/glutton/pkg/iface"
+	"github.com/defectus/glutton/pkg/seal"
 	"github.com/pkg/errors"
 )
 
 // SimpleFileSystemSaver saves request to filesystem.
 type SimpleFileSystemSaver struct {
+	uri      string
 	root     string
 	basename string
 	counter  int64
+	sealer   *seal.Sealer
+	janitor  *janitor
 	debug    bool
 }
 
@@ -25,26 +29,74 @@ func (s *SimpleFileSystemSaver) Save(payload *iface.PayloadRecord) error {
 	if s.debug {
 		log.Printf("SimpleFileSystemSaver_Save: output file %s", s.filename(index))
 	}
+	content := payload.String()
+	if s.sealer != nil {
+		sealed, err := s.sealer.Seal([]byte(content))
+		if err != nil {
+			return errors.Wrap(err, "error sealing payload")
+		}
+		content = sealed + "\n"
+	}
 	f, err := os.OpenFile(s.filename(index), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return errors.Wrapf(err, "error opening outfile %s", s.filename(index))
 	}
-	_, err = f.WriteString(payload.String())
+	_, err = f.WriteString(content)
 	if err != nil {
 		return errors.Wrapf(err, "error writing to outfile %s", s.filename(index))
 	}
 	err = f.Close()
-	return errors.Wrapf(err, "error closing output %s", payload.String())
+	return errors.Wrapf(err, "error closing output %s", s.filename(index))
 }
 
 // Configure bootstraps the SimpleFileSystemSaver
 // Namely the following params are used:
 // * OutputFolder
 // * BaseName - first part of the name
-func (s *SimpleFileSystemSaver) Configure(settings *iface.Settings) error {
+// * EncryptionMasterKey, EncryptionRecipient - seal the whole file content if either is set
+// * RetentionMaxAge, RetentionMaxCount, RetentionMaxBytes, RetentionInterval - periodic removal of old files, refused if another route writes to the same folder and base name
+func (s *SimpleFileSystemSaver) Configure(settings *iface.Settings) (err error) {
+	s.uri = settings.URI
 	s.root = settings.OutputFolder
 	s.basename = settings.BaseName
 	s.debug = settings.Debug
+	if s.sealer, err = newSealer(settings); err != nil {
+		return err
+	}
+	if s.janitor, err = newJanitor("SimpleFileSystemSaver "+settings.URI, settings, s.purgeFiles); err != nil {
+		return err
+	}
+	if err = s.claimFiles(); err != nil {
+		s.janitor = nil
+		return err
+	}
+	if s.janitor != nil {
+		s.janitor.start()
+	}
+	return nil
+}
+
+// Close stops the retention janitor and releases the files.
+func (s *SimpleFileSystemSaver) Close() error {
+	s.janitor.Close()
+	s.releaseFiles()
+	return nil
+}
+
+// newSealer creates a sealer if encryption at rest is configured, nil otherwise.
+func newSealer(settings *iface.Settings) (*seal.Sealer, error) {
+	if len(settings.EncryptionMasterKey) == 0 && len(settings.EncryptionRecipient) == 0 {
+		return nil, nil
+	}
+	sealer, err := seal.NewSealer(settings.EncryptionMasterKey, settings.EncryptionRecipient)
+	return sealer, errors.Wrap(err, "error configuring encryption")
+}
+
+// refuseSealing fails the configuration of a saver that can't seal records of a route with encryption at rest configured.
+func refuseSealing(saver string, settings *iface.Settings) error {
+	if len(settings.EncryptionMasterKey) > 0 || len(settings.EncryptionRecipient) > 0 {
+		return errors.Errorf("%s doesn't support encryption at rest, use SimpleFileSystemSaver or DatabaseSaver", saver)
+	}
 	return nil
 }
 
@@ -0,0 +1,178 @@
+package saver
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	// registers the pure go "sqlite" driver, no cgo needed
+	_ "modernc.org/sqlite"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const defaultSQLitePath = "glutton.db"
+
+// sqliteMigrations are applied in order, the index of the last applied one is kept in `PRAGMA user_version`.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS payload (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		route TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		remote TEXT,
+		method TEXT,
+		headers TEXT,
+		body BLOB
+	)`,
+	`CREATE INDEX IF NOT EXISTS payload_route_timestamp ON payload(route, timestamp)`,
+	`ALTER TABLE payload ADD COLUMN url TEXT`,
+	`ALTER TABLE payload ADD COLUMN received INTEGER`,
+}
+
+const (
+	sqliteInsert = "INSERT INTO payload(route, timestamp, remote, method, url, received, headers, body) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	sqliteSelect = "SELECT route, timestamp, remote, method, url, received, headers, body FROM payload"
+)
+
+// SQLiteSaver saves payload to a local SQLite database. The database file and table are created (and migrated) on start.
+type SQLiteSaver struct {
+	db      *sql.DB
+	route   string
+	janitor *janitor
+	debug   bool
+}
+
+// Configure opens (creates) the database file and brings its schema up to date.
+// Namely the following params are used:
+// * SQLitePath - location of the database file
+// * URI - stored with every record as its route
+// * RetentionMaxAge, RetentionMaxCount, RetentionMaxBytes, RetentionInterval - periodic removal of old records of the route
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (s *SQLiteSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("SQLiteSaver", settings); err != nil {
+		return err
+	}
+	path := settings.SQLitePath
+	if len(path) == 0 {
+		path = defaultSQLitePath
+	}
+	s.route = settings.URI
+	s.debug = settings.Debug
+	s.db, err = sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return errors.Wrapf(err, "error opening sqlite database %s", path)
+	}
+	defer func() {
+		if err != nil {
+			s.db.Close()
+		}
+	}()
+	// sqlite allows a single writer only, queue the writes here rather than in the driver
+	s.db.SetMaxOpenConns(1)
+	if err = migrateSQLite(s.db, s.debug); err != nil {
+		return errors.Wrapf(err, "error migrating sqlite database %s", path)
+	}
+	retention := &tableRetention{db: s.db, dialect: sqliteDialect, table: "payload", timestamp: "timestamp", body: "body", route: s.route}
+	s.janitor, err = startJanitor("SQLiteSaver "+s.route, settings, retention.purge)
+	return err
+}
+
+// Save stores the payload as a new row.
+func (s *SQLiteSaver) Save(payload *iface.PayloadRecord) error {
+	headers, err := json.Marshal(payload.Meta)
+	if err != nil {
+		return errors.Wrap(err, "error encoding headers")
+	}
+	_, err = s.db.Exec(sqliteInsert, s.route, payload.Timestamp, payload.Remote, payload.Method, payload.URL, int64(payload.Received), string(headers), []byte(payload.Payload))
+	return errors.Wrap(err, "error saving payload")
+}
+
+// ReadSQLite reads records saved by the SQLiteSaver to the given database, of all routes if route is empty. Records are passed to fn oldest first.
+func ReadSQLite(path, route string, fn func(*iface.PayloadRecord) error) error {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return errors.Wrapf(err, "error opening sqlite database %s", path)
+	}
+	defer db.Close()
+	query, args := sqliteSelect+" ORDER BY id", []interface{}{}
+	if len(route) > 0 {
+		query, args = sqliteSelect+" WHERE route = ? ORDER BY id", append(args, route)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return errors.Wrapf(err, "error reading sqlite database %s", path)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			payload             = new(iface.PayloadRecord)
+			stored              string
+			remote, method, url sql.NullString
+			received            sql.NullInt64
+			headers             sql.NullString
+			body                []byte
+		)
+		if err = rows.Scan(&stored, &payload.Timestamp, &remote, &method, &url, &received, &headers, &body); err != nil {
+			return errors.Wrap(err, "error reading record")
+		}
+		payload.Remote, payload.Method, payload.URL, payload.Payload = remote.String, method.String, url.String, string(body)
+		payload.Received = time.Duration(received.Int64)
+		if len(payload.URL) == 0 {
+			// recorded before URLs were kept
+			payload.URL = RouteURL(stored)
+		}
+		if len(headers.String) > 0 {
+			if err = json.Unmarshal([]byte(headers.String), &payload.Meta); err != nil {
+				return errors.Wrap(err, "error decoding headers")
+			}
+		}
+		if err = fn(payload); err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(rows.Err(), "error reading sqlite database %s", path)
+}
+
+// Health pings the database.
+func (s *SQLiteSaver) Health() error {
+	return errors.Wrap(s.db.Ping(), "error pinging sqlite database")
+}
+
+// Close stops the retention janitor and closes the database.
+func (s *SQLiteSaver) Close() error {
+	s.janitor.Close()
+	return s.db.Close()
+}
+
+// migrateSQLite applies all migrations not yet recorded in the database.
+func migrateSQLite(db *sql.DB, debug bool) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return errors.Wrap(err, "error reading schema version")
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		if debug {
+			log.Printf("SQLiteSaver_migrate: applying migration %d", version+1)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "error starting migration")
+		}
+		if _, err = tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error applying migration %d", version+1)
+		}
+		// PRAGMA doesn't accept bound parameters
+		if _, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "error recording migration %d", version+1)
+		}
+		if err = tx.Commit(); err != nil {
+			return errors.Wrapf(err, "error committing migration %d", version+1)
+		}
+	}
+	return nil
+}
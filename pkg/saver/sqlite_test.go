@@ -0,0 +1,67 @@
+package saver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteSaver_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	settings := &iface.Settings{URI: "save", SQLitePath: filepath.Join(dir, "test.db")}
+	s := new(SQLiteSaver)
+	assert.NoError(t, s.Configure(settings))
+	err = s.Save(&iface.PayloadRecord{
+		Payload:   "test payload",
+		Timestamp: time.Now(),
+		Remote:    "127.0.0.1",
+		Method:    "POST",
+		Meta:      map[string][]string{"Content-Type": {"text/plain"}},
+	})
+	assert.NoError(t, err)
+	var route, method, headers string
+	var body []byte
+	err = s.db.QueryRow("SELECT route, method, headers, body FROM payload").Scan(&route, &method, &headers, &body)
+	assert.NoError(t, err)
+	assert.Equal(t, "save", route)
+	assert.Equal(t, "POST", method)
+	assert.Equal(t, "test payload", string(body))
+	meta := map[string][]string{}
+	assert.NoError(t, json.Unmarshal([]byte(headers), &meta))
+	assert.Equal(t, "text/plain", meta["Content-Type"][0])
+}
+
+func TestSQLiteSaver_Migrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	settings := &iface.Settings{URI: "save", SQLitePath: filepath.Join(dir, "test.db")}
+	// configuring twice must not fail on an already migrated database
+	assert.NoError(t, new(SQLiteSaver).Configure(settings))
+	s := new(SQLiteSaver)
+	assert.NoError(t, s.Configure(settings))
+	var version int
+	assert.NoError(t, s.db.QueryRow("PRAGMA user_version").Scan(&version))
+	assert.Equal(t, len(sqliteMigrations), version)
+}
+
+func TestRefuseSealing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	settings := &iface.Settings{URI: "save", SQLitePath: filepath.Join(dir, "test.db"), WARCPath: filepath.Join(dir, "test.warc"), MboxPath: filepath.Join(dir, "test.mbox"), KafkaBrokers: []string{"localhost:9092"}, EncryptionRecipient: "age1recipient"}
+	for _, s := range []iface.PayloadSaver{new(SQLiteSaver), new(WARCSaver), new(MboxSaver), new(KafkaSaver), new(S3Saver)} {
+		assert.Error(t, s.Configure(settings))
+	}
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
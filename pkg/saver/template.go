@@ -0,0 +1,48 @@
+package saver
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/pkg/errors"
+)
+
+// recordData is a record along with the route it was received on. It's what templates (object keys, topics ...) refer to and what's encoded as JSON.
+type recordData struct {
+	Route string `json:"route"`
+	// Index is a per saver counter, handy to make names unique.
+	Index int64 `json:"-"`
+	*iface.PayloadRecord
+}
+
+// parseTemplate parses a template used to name things after records, empty text yields the fallback.
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if len(text) == 0 {
+		text = fallback
+	}
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	return t, errors.Wrapf(err, "error parsing %s template %s", name, text)
+}
+
+// executeTemplate renders the template for the given record.
+func executeTemplate(t *template.Template, data *recordData) (string, error) {
+	var buffer bytes.Buffer
+	if err := t.Execute(&buffer, data); err != nil {
+		return "", errors.Wrapf(err, "error executing %s template", t.Name())
+	}
+	return buffer.String(), nil
+}
+
+// encodeRecord encodes record as `json` (a single line) or `text` (the default).
+func encodeRecord(format string, data *recordData) ([]byte, error) {
+	if format != "json" {
+		return []byte(data.PayloadRecord.String()), nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding record")
+	}
+	return append(encoded, '\n'), nil
+}
@@ -0,0 +1,184 @@
+package saver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/defectus/glutton/pkg/iface"
+)
+
+const defaultWARCPath = "glutton.warc"
+
+// WARCWriter writes payload as WARC 1.1 `request` records. Compressed records are written as separate gzip members, as usual for `.warc.gz` files.
+type WARCWriter struct {
+	w        io.Writer
+	compress bool
+	// route makes up the target URI of payload without URL
+	route string
+}
+
+// NewWARCWriter creates a WARCWriter writing to w.
+func NewWARCWriter(w io.Writer, compress bool) *WARCWriter {
+	return &WARCWriter{w: w, compress: compress}
+}
+
+// WriteInfo writes the `warcinfo` record that usually starts a WARC file.
+func (w *WARCWriter) WriteInfo(filename string) error {
+	block := "software: glutton\r\nformat: WARC File Format 1.1\r\n"
+	return w.write([][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", filename},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(block))
+}
+
+// WriteRequest writes the payload as a `request` record holding the HTTP request message. Payload without URL is targeted at the route.
+func (w *WARCWriter) WriteRequest(payload *iface.PayloadRecord) error {
+	target := payload.URL
+	if len(target) == 0 {
+		target = RouteURL(w.route)
+	}
+	block := httpRequestMessage(payload, target)
+	digest := sha1.Sum(block)
+	fields := [][2]string{
+		{"WARC-Type", "request"},
+		{"WARC-Date", payload.Timestamp.UTC().Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", target},
+		{"Content-Type", "application/http;msgtype=request"},
+		{"WARC-Block-Digest", "sha1:" + base32.StdEncoding.EncodeToString(digest[:])},
+	}
+	if host, _, err := net.SplitHostPort(payload.Remote); err == nil {
+		fields = append(fields, [2]string{"WARC-IP-Address", host})
+	}
+	return w.write(fields, block)
+}
+
+// write writes a single record, WARC-Record-ID and Content-Length are added to the fields.
+func (w *WARCWriter) write(fields [][2]string, block []byte) error {
+	var record bytes.Buffer
+	record.WriteString("WARC/1.1\r\n")
+	fields = append([][2]string{{"WARC-Record-ID", "<urn:uuid:" + uuid.NewString() + ">"}}, fields...)
+	for _, field := range append(fields, [2]string{"Content-Length", fmt.Sprint(len(block))}) {
+		if len(field[1]) > 0 {
+			record.WriteString(field[0] + ": " + field[1] + "\r\n")
+		}
+	}
+	record.WriteString("\r\n")
+	record.Write(block)
+	record.WriteString("\r\n\r\n")
+	if !w.compress {
+		_, err := w.w.Write(record.Bytes())
+		return errors.Wrap(err, "error writing warc record")
+	}
+	gz := gzip.NewWriter(w.w)
+	if _, err := gz.Write(record.Bytes()); err != nil {
+		return errors.Wrap(err, "error writing warc record")
+	}
+	return errors.Wrap(gz.Close(), "error writing warc record")
+}
+
+// RouteURL is the URL of the route on a local glutton, it stands in for the URL of payload that doesn't have one.
+func RouteURL(route string) string {
+	u := url.URL{Scheme: "http", Host: "localhost", Path: "/v1/glutton/" + strings.TrimPrefix(route, "/")}
+	return u.String()
+}
+
+// httpRequestMessage rebuilds the HTTP request message of the payload sent to the given URL, headers are sorted by name.
+func httpRequestMessage(payload *iface.PayloadRecord, target string) []byte {
+	var message bytes.Buffer
+	host := ""
+	if u, err := url.Parse(target); err == nil {
+		target, host = u.RequestURI(), u.Host
+	} else {
+		target = "/"
+	}
+	method := payload.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+	message.WriteString(method + " " + target + " HTTP/1.1\r\n")
+	if len(host) > 0 {
+		message.WriteString("Host: " + host + "\r\n")
+	}
+	names := make([]string, 0, len(payload.Meta))
+	for name := range payload.Meta {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range payload.Meta[name] {
+			message.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	message.WriteString("\r\n")
+	message.WriteString(payload.Payload)
+	return message.Bytes()
+}
+
+// WARCSaver appends payload to a WARC file as `request` records. Files ending with `.gz` are compressed.
+type WARCSaver struct {
+	path   string
+	mutex  sync.Mutex
+	file   *os.File
+	writer *WARCWriter
+	debug  bool
+}
+
+// Configure opens (creates) the WARC file.
+// Namely the following params are used:
+// * WARCPath - file to append to, a new file starts with a `warcinfo` record
+// * URI - the route, target of payload without URL
+// * EncryptionMasterKey, EncryptionRecipient - must not be set, records are not sealed
+func (s *WARCSaver) Configure(settings *iface.Settings) (err error) {
+	if err = refuseSealing("WARCSaver", settings); err != nil {
+		return err
+	}
+	s.path = settings.WARCPath
+	if len(s.path) == 0 {
+		s.path = defaultWARCPath
+	}
+	s.debug = settings.Debug
+	if s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		return errors.Wrapf(err, "error opening warc file %s", s.path)
+	}
+	s.writer = NewWARCWriter(s.file, strings.HasSuffix(s.path, ".gz"))
+	s.writer.route = settings.URI
+	info, err := s.file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "error opening warc file %s", s.path)
+	}
+	if info.Size() == 0 {
+		return s.writer.WriteInfo(info.Name())
+	}
+	return nil
+}
+
+// Save appends the payload as a `request` record.
+func (s *WARCSaver) Save(payload *iface.PayloadRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return errors.Wrapf(s.writer.WriteRequest(payload), "error saving payload to %s", s.path)
+}
+
+// Close closes the WARC file.
+func (s *WARCSaver) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
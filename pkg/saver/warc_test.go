@@ -0,0 +1,98 @@
+package saver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defectus/glutton/pkg/iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// readWARC reads records as maps of their fields, the block is kept under "block".
+func readWARC(t *testing.T, r io.Reader) []map[string]string {
+	records := []map[string]string{}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return records
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, "WARC/1.1\r\n", line)
+		record := map[string]string{}
+		for {
+			line, err = reader.ReadString('\n')
+			assert.NoError(t, err)
+			if line == "\r\n" {
+				break
+			}
+			parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+			record[parts[0]] = parts[1]
+		}
+		length, err := strconv.Atoi(record["Content-Length"])
+		assert.NoError(t, err)
+		block := make([]byte, length+4)
+		_, err = io.ReadFull(reader, block)
+		assert.NoError(t, err)
+		assert.Equal(t, "\r\n\r\n", string(block[length:]))
+		record["block"] = string(block[:length])
+		records = append(records, record)
+	}
+}
+
+func TestWARCSaver_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glutton")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"test.warc", "test.warc.gz"} {
+		path := filepath.Join(dir, name)
+		s := new(WARCSaver)
+		assert.NoError(t, s.Configure(&iface.Settings{WARCPath: path}))
+		payload := &iface.PayloadRecord{
+			Payload:   "a=1",
+			Timestamp: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Remote:    "10.0.0.1:5000",
+			Method:    "POST",
+			URL:       "http://localhost:8080/v1/glutton/save?x=y",
+			Meta:      map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}},
+		}
+		assert.NoError(t, s.Save(payload))
+		assert.NoError(t, s.Close())
+		// reopening appends
+		assert.NoError(t, s.Configure(&iface.Settings{WARCPath: path}))
+		assert.NoError(t, s.Save(payload))
+		assert.NoError(t, s.Close())
+		f, err := os.Open(path)
+		assert.NoError(t, err)
+		var r io.Reader = f
+		if strings.HasSuffix(name, ".gz") {
+			r, err = gzip.NewReader(f)
+			assert.NoError(t, err)
+		}
+		records := readWARC(t, r)
+		f.Close()
+		assert.Len(t, records, 3)
+		assert.Equal(t, "warcinfo", records[0]["WARC-Type"])
+		request := records[1]
+		assert.Equal(t, "request", request["WARC-Type"])
+		assert.Equal(t, "2020-01-02T03:04:05Z", request["WARC-Date"])
+		assert.Equal(t, payload.URL, request["WARC-Target-URI"])
+		assert.Equal(t, "10.0.0.1", request["WARC-IP-Address"])
+		assert.NotEqual(t, request["WARC-Record-ID"], records[2]["WARC-Record-ID"])
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(request["block"])))
+		assert.NoError(t, err)
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "/v1/glutton/save?x=y", req.RequestURI)
+		assert.Equal(t, "localhost:8080", req.Host)
+		assert.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,229 @@
+// Package seal implements envelope encryption of saved payload. Every payload is encrypted (AES-GCM) with a fresh data key, the data key is wrapped by a master key (AES-GCM as well) or for an age X25519 recipient.
+// Sealed payload is armored to a single line of text, so it can be appended to files and stored in text columns.
+package seal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+)
+
+// Prefix starts every armored sealed payload.
+const Prefix = "glutton-sealed:v1:"
+
+const (
+	keySize = 32
+	// wrapping of the data key
+	wrapMasterKey = 'K'
+	wrapAge       = 'A'
+)
+
+// Sealer seals payload.
+type Sealer struct {
+	masterKey cipher.AEAD
+	recipient age.Recipient
+}
+
+// NewSealer creates a sealer wrapping data keys with the master key (base64 encoded 32 bytes) or, if empty, for the age recipient (age1...).
+func NewSealer(masterKey, recipient string) (*Sealer, error) {
+	s := new(Sealer)
+	switch {
+	case len(masterKey) > 0:
+		aead, err := masterKeyAEAD(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		s.masterKey = aead
+	case len(recipient) > 0:
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing age recipient")
+		}
+		s.recipient = r
+	default:
+		return nil, errors.New("neither master key nor recipient configured")
+	}
+	return s, nil
+}
+
+// Seal encrypts plain text and returns it armored.
+func (s *Sealer) Seal(plain []byte) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", errors.Wrap(err, "error generating data key")
+	}
+	var (
+		wrapType byte
+		wrapped  []byte
+		err      error
+	)
+	if s.masterKey != nil {
+		wrapType = wrapMasterKey
+		wrapped, err = sealAEAD(s.masterKey, dataKey, nil)
+	} else {
+		wrapType = wrapAge
+		wrapped, err = wrapAgeKey(s.recipient, dataKey)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "error wrapping data key")
+	}
+	header := make([]byte, 5, 5+len(wrapped))
+	header[0] = wrapType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(wrapped)))
+	header = append(header, wrapped...)
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	// the header is authenticated along with the payload
+	sealed, err := sealAEAD(aead, plain, header)
+	if err != nil {
+		return "", errors.Wrap(err, "error sealing payload")
+	}
+	return Prefix + base64.RawStdEncoding.EncodeToString(append(header, sealed...)), nil
+}
+
+// Opener opens sealed payload.
+type Opener struct {
+	masterKey  cipher.AEAD
+	identities []age.Identity
+}
+
+// NewOpener creates an opener using the master key (base64 encoded 32 bytes) and/or age identities (contents of an age identity file).
+func NewOpener(masterKey string, identities io.Reader) (*Opener, error) {
+	o := new(Opener)
+	if len(masterKey) > 0 {
+		aead, err := masterKeyAEAD(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		o.masterKey = aead
+	}
+	if identities != nil {
+		parsed, err := age.ParseIdentities(identities)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing age identities")
+		}
+		o.identities = parsed
+	}
+	if o.masterKey == nil && len(o.identities) == 0 {
+		return nil, errors.New("neither master key nor identities provided")
+	}
+	return o, nil
+}
+
+// Open decrypts armored sealed payload.
+func (o *Opener) Open(armored string) ([]byte, error) {
+	if !IsSealed(armored) {
+		return nil, errors.New("not a sealed payload")
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimSpace(armored[len(Prefix):]))
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding sealed payload")
+	}
+	if len(raw) < 5 {
+		return nil, errors.New("sealed payload too short")
+	}
+	length := int(binary.BigEndian.Uint32(raw[1:5]))
+	if len(raw) < 5+length {
+		return nil, errors.New("sealed payload too short")
+	}
+	header, wrapped, sealed := raw[:5+length], raw[5:5+length], raw[5+length:]
+	var dataKey []byte
+	switch raw[0] {
+	case wrapMasterKey:
+		if o.masterKey == nil {
+			return nil, errors.New("payload sealed with a master key, none provided")
+		}
+		dataKey, err = openAEAD(o.masterKey, wrapped, nil)
+	case wrapAge:
+		if len(o.identities) == 0 {
+			return nil, errors.New("payload sealed for an age recipient, no identity provided")
+		}
+		dataKey, err = unwrapAgeKey(o.identities, wrapped)
+	default:
+		return nil, errors.Errorf("unknown key wrapping %q", raw[0])
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error unwrapping data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openAEAD(aead, sealed, header)
+	return plain, errors.Wrap(err, "error opening payload")
+}
+
+// IsSealed tells whether text is an armored sealed payload.
+func IsSealed(text string) bool {
+	return strings.HasPrefix(text, Prefix)
+}
+
+func masterKeyAEAD(masterKey string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(masterKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding master key, base64 expected")
+	}
+	if len(key) != keySize {
+		return nil, errors.Errorf("master key must be %d bytes long, got %d", keySize, len(key))
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.Wrap(err, "error creating gcm")
+}
+
+// sealAEAD encrypts plain text and prepends the random nonce.
+func sealAEAD(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func openAEAD(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+func wrapAgeKey(recipient age.Recipient, dataKey []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	w, err := age.Encrypt(&buffer, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(dataKey); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func unwrapAgeKey(identities []age.Identity, wrapped []byte) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(wrapped), identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,69 @@
+package seal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealMasterKey(t *testing.T) {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	masterKey := base64.StdEncoding.EncodeToString(key)
+	sealer, err := NewSealer(masterKey, "")
+	assert.NoError(t, err)
+	sealed, err := sealer.Seal([]byte("name=john&email=john@example.com"))
+	assert.NoError(t, err)
+	assert.True(t, IsSealed(sealed))
+	assert.NotContains(t, sealed, "john")
+	assert.NotContains(t, sealed, "\n")
+	opener, err := NewOpener(masterKey, nil)
+	assert.NoError(t, err)
+	plain, err := opener.Open(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "name=john&email=john@example.com", string(plain))
+	// tampering is detected
+	tampered := []byte(sealed)
+	tampered[len(tampered)-3] ^= 1
+	_, err = opener.Open(string(tampered))
+	assert.Error(t, err)
+	// a different key doesn't open it
+	rand.Read(key)
+	other, err := NewOpener(base64.StdEncoding.EncodeToString(key), nil)
+	assert.NoError(t, err)
+	_, err = other.Open(sealed)
+	assert.Error(t, err)
+}
+
+func TestSealAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+	sealer, err := NewSealer("", identity.Recipient().String())
+	assert.NoError(t, err)
+	sealed, err := sealer.Seal([]byte("secret"))
+	assert.NoError(t, err)
+	opener, err := NewOpener("", strings.NewReader("# test identity\n"+identity.String()+"\n"))
+	assert.NoError(t, err)
+	plain, err := opener.Open(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plain))
+	// master key only opener can't open it
+	key := make([]byte, keySize)
+	master, err := NewOpener(base64.StdEncoding.EncodeToString(key), nil)
+	assert.NoError(t, err)
+	_, err = master.Open(sealed)
+	assert.Error(t, err)
+}
+
+func TestNewSealer(t *testing.T) {
+	_, err := NewSealer("", "")
+	assert.Error(t, err)
+	_, err = NewSealer("c2hvcnQ=", "")
+	assert.Error(t, err)
+	_, err = NewSealer("", "age1invalid")
+	assert.Error(t, err)
+}